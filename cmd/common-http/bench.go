@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	common "github.com/broxgit/common/http"
+)
+
+type benchResult struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+type benchReport struct {
+	Requests   int            `json:"requests"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Attempts   int64          `json:"attempts"`
+	Retries    int64          `json:"retries"`
+	Duration   time.Duration  `json:"duration"`
+	Throughput float64        `json:"throughput"`
+	Latency    benchLatency   `json:"latency"`
+	Statuses   map[string]int `json:"statuses"`
+	Errors     map[string]int `json:"errors,omitempty"`
+}
+
+// benchLatency is in nanoseconds in JSON.
+type benchLatency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+func runBench(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("common-http bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: common-http bench [flags] URL\n\nflags:")
+		fs.PrintDefaults()
+	}
+	var c clientFlags
+	c.register(fs)
+	concurrency := fs.Int("c", 10, "concurrent workers")
+	rate := fs.Float64("rate", 0, "requests per second across all workers, 0 for as fast as possible")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run")
+	total := fs.Int("n", 0, "stop after this many requests, 0 to run for -duration")
+	output := fs.String("o", "text", "report format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 || *concurrency < 1 || *rate < 0 || math.IsInf(*rate, 0) || math.IsNaN(*rate) || (*output != "text" && *output != "json") {
+		fs.Usage()
+		return exitUsage
+	}
+	if c.verbose {
+		log.Logger = log.Logger.Level(zerolog.WarnLevel)
+	}
+
+	client, err := c.client(common.WithStats(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	// Connections are reused across workers rather than capped at the
+	// transport's default of two idle connections per host.
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t = t.Clone()
+		t.MaxIdleConnsPerHost = *concurrency
+		client.HTTPClient.Transport = t
+	}
+	body, err := c.body(stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	target := fs.Arg(0)
+	if _, err := c.newRequest(context.Background(), target, body); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	// Requests in flight when the duration ends are allowed to finish; only
+	// an interrupt cancels them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	issueCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	tickets := make(chan struct{})
+	go issueTickets(issueCtx, tickets, *rate, *total)
+
+	results := make(chan benchResult, *concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range tickets {
+				results <- benchOnce(ctx, client, &c, target, body)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	var collected []benchResult
+	for r := range results {
+		collected = append(collected, r)
+	}
+	report := summarize(collected, time.Since(start), client.Stats())
+
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	} else {
+		writeBenchReport(stdout, report)
+	}
+	return exitOK
+}
+
+// issueTickets hands out one ticket per request to send, paced by rate when
+// it is positive, until ctx is done or total tickets have been issued.
+func issueTickets(ctx context.Context, tickets chan<- struct{}, rate float64, total int) {
+	defer close(tickets)
+	var tick <-chan time.Time
+	if rate > 0 {
+		// Rates above one per nanosecond are paced as fast as a ticker allows.
+		interval := time.Duration(float64(time.Second) / rate)
+		if interval < 1 {
+			interval = 1
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for issued := 0; total <= 0 || issued < total; issued++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case tickets <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func benchOnce(ctx context.Context, client *common.HTTPRetry, c *clientFlags, target string, body []byte) benchResult {
+	req, err := c.newRequest(ctx, target, body)
+	if err != nil {
+		return benchResult{err: err}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return benchResult{latency: time.Since(start), err: err}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return benchResult{latency: time.Since(start), status: resp.StatusCode, err: err}
+}
+
+func summarize(results []benchResult, elapsed time.Duration, stats []common.HostStats) benchReport {
+	report := benchReport{
+		Requests: len(results),
+		Duration: elapsed,
+		Statuses: map[string]int{},
+		Errors:   map[string]int{},
+	}
+	latencies := make([]time.Duration, 0, len(results))
+	var sum time.Duration
+	for _, r := range results {
+		latencies = append(latencies, r.latency)
+		sum += r.latency
+		switch {
+		case r.err != nil:
+			report.Failed++
+			var exhausted *common.RetriesExhaustedError
+			if errors.As(r.err, &exhausted) && exhausted.StatusCode != 0 {
+				report.Statuses[strconv.Itoa(exhausted.StatusCode)]++
+			} else {
+				report.Errors[errorKind(r.err)]++
+			}
+		case r.status >= 400:
+			report.Failed++
+			report.Statuses[strconv.Itoa(r.status)]++
+		default:
+			report.Succeeded++
+			report.Statuses[strconv.Itoa(r.status)]++
+		}
+	}
+	for _, s := range stats {
+		report.Attempts += s.Attempts
+		report.Retries += s.Retries
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		at := func(q float64) time.Duration { return latencies[int(q*float64(len(latencies)-1))] }
+		report.Latency = benchLatency{
+			Min:  latencies[0],
+			Mean: sum / time.Duration(len(latencies)),
+			P50:  at(0.5),
+			P90:  at(0.9),
+			P95:  at(0.95),
+			P99:  at(0.99),
+			Max:  latencies[len(latencies)-1],
+		}
+	}
+	return report
+}
+
+func errorKind(err error) string {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, common.ErrResponseTooLarge):
+		return "response too large"
+	case errors.As(err, &opErr):
+		return opErr.Op + ": " + opErr.Err.Error()
+	default:
+		return err.Error()
+	}
+}
+
+func writeBenchReport(w io.Writer, r benchReport) {
+	fmt.Fprintf(w, "Requests:    %d in %s\n", r.Requests, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:  %.2f req/s\n", r.Throughput)
+	fmt.Fprintf(w, "Succeeded:   %d\n", r.Succeeded)
+	fmt.Fprintf(w, "Failed:      %d\n", r.Failed)
+	fmt.Fprintf(w, "Attempts:    %d (%d retries)\n", r.Attempts, r.Retries)
+	fmt.Fprintln(w, "\nLatency:")
+	l := r.Latency
+	for _, row := range []struct {
+		name string
+		d    time.Duration
+	}{{"min", l.Min}, {"mean", l.Mean}, {"p50", l.P50}, {"p90", l.P90}, {"p95", l.P95}, {"p99", l.P99}, {"max", l.Max}} {
+		fmt.Fprintf(w, "  %-5s %s\n", row.name, row.d.Round(time.Microsecond))
+	}
+	writeCounts(w, "Status codes:", r.Statuses)
+	writeCounts(w, "Errors:", r.Errors)
+}
+
+func writeCounts(w io.Writer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return counts[keys[i]] > counts[keys[j]] || (counts[keys[i]] == counts[keys[j]] && keys[i] < keys[j])
+	})
+	fmt.Fprintln(w, "\n"+title)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-8d %s\n", counts[k], k)
+	}
+}
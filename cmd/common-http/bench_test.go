@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+func TestSummarizeGroupsExhaustedRetries(t *testing.T) {
+	results := []benchResult{
+		{status: 200},
+		{err: &common.RetriesExhaustedError{Attempts: 3, StatusCode: 503, Status: "503 Service Unavailable"}},
+		{err: &common.RetriesExhaustedError{Attempts: 3, Err: fmt.Errorf("dial: %w", context.DeadlineExceeded)}},
+	}
+	r := summarize(results, time.Second, nil)
+	if r.Failed != 2 || r.Statuses["503"] != 1 || r.Errors["timeout"] != 1 {
+		t.Fatalf("report = %+v", r)
+	}
+}
+
+func TestIssueTicketsHighRate(t *testing.T) {
+	tickets := make(chan struct{})
+	go issueTickets(context.Background(), tickets, 1e12, 3)
+	n := 0
+	for range tickets {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("issued %d tickets, want 3", n)
+	}
+}
+
+func TestRunBenchRejectsInvalidRate(t *testing.T) {
+	for _, rate := range []string{"-1", "NaN", "+Inf"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"bench", "-rate", rate, "-n", "1", "http://127.0.0.1:1"}, strings.NewReader(""), &stdout, &stderr)
+		if code != exitUsage {
+			t.Errorf("-rate %s: exit code = %d, want %d", rate, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Command common-http performs HTTP requests with the retry, backoff and
+// redaction behaviour of github.com/broxgit/common/http.
+//
+//	common-http [flags] URL
+//	common-http bench [flags] URL
+//
+// The bench command fires requests at URL with configurable concurrency, rate
+// and duration and reports throughput, latency percentiles, retries and
+// errors. Run either command with -h for the list of flags.
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitHTTPError = 22
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	// The library logs every retry; only show them when asked to with -v.
+	log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: stderr, NoColor: true}).With().Timestamp().Logger().Level(zerolog.ErrorLevel)
+	if len(args) > 0 && args[0] == "bench" {
+		return runBench(args[1:], stdin, stdout, stderr)
+	}
+	return runRequest(args, stdin, stdout, stderr)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	common "github.com/broxgit/common/http"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q is not of the form \"Name: value\"", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// clientFlags are shared by the request and bench commands.
+type clientFlags struct {
+	method   string
+	headers  headerFlags
+	data     string
+	retries  int
+	backoff  int
+	strategy string
+	timeout  time.Duration
+	user     string
+	bearer   string
+	maxSize  int64
+	verbose  bool
+}
+
+func (c *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.method, "X", "", "request method (default GET, or POST with -d)")
+	fs.Var(&c.headers, "H", "request header \"Name: value\", repeatable")
+	fs.StringVar(&c.data, "d", "", "request body; @file reads a file, @- reads stdin")
+	fs.IntVar(&c.retries, "retries", 3, "attempts per request")
+	fs.IntVar(&c.backoff, "backoff", 2, "base backoff in seconds")
+	fs.StringVar(&c.strategy, "backoff-strategy", "linear", "backoff strategy: linear, exponential or constant")
+	fs.DurationVar(&c.timeout, "timeout", 30*time.Second, "per-attempt timeout")
+	fs.StringVar(&c.user, "u", "", "basic auth credentials as user:password")
+	fs.StringVar(&c.bearer, "bearer", "", "bearer token (default $COMMON_HTTP_TOKEN)")
+	fs.Int64Var(&c.maxSize, "max-size", 0, "maximum response size in bytes, 0 for no limit")
+	fs.BoolVar(&c.verbose, "v", false, "log the redacted request, response status and retries to stderr")
+}
+
+func (c *clientFlags) client(options ...func(*common.HTTPRetry)) (*common.HTTPRetry, error) {
+	var strategy common.BackoffStrategy
+	switch c.strategy {
+	case "linear":
+		strategy = common.LinearBackoff
+	case "exponential":
+		strategy = common.ExponentialBackoff
+	case "constant":
+		strategy = common.ConstantBackoff
+	default:
+		return nil, fmt.Errorf("unknown backoff strategy %q", c.strategy)
+	}
+	return common.NewHTTPRetry(append([]func(*common.HTTPRetry){
+		common.WithRetries(c.retries),
+		common.WithBackoff(c.backoff),
+		common.WithBackoffStrategy(strategy),
+		common.WithTimeout(c.timeout),
+		common.WithMaxResponseSize(c.maxSize),
+	}, options...)...), nil
+}
+
+// body reads the request body named by -d once so it can be reused.
+func (c *clientFlags) body(stdin io.Reader) ([]byte, error) {
+	switch {
+	case c.data == "":
+		return nil, nil
+	case c.data == "@-":
+		return io.ReadAll(stdin)
+	case strings.HasPrefix(c.data, "@"):
+		return os.ReadFile(c.data[1:])
+	default:
+		return []byte(c.data), nil
+	}
+}
+
+func (c *clientFlags) newRequest(ctx context.Context, target string, body []byte) (*http.Request, error) {
+	method := c.method
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), target, r)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range c.headers {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" && json.Valid(body) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.user != "" {
+		user, password, _ := strings.Cut(c.user, ":")
+		req.SetBasicAuth(user, password)
+	}
+	bearer := c.bearer
+	if bearer == "" {
+		bearer = os.Getenv("COMMON_HTTP_TOKEN")
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return req, nil
+}
+
+func runRequest(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("common-http", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: common-http [flags] URL\n       common-http bench [flags] URL\n\nflags:")
+		fs.PrintDefaults()
+	}
+	var c clientFlags
+	c.register(fs)
+	include := fs.Bool("i", false, "include the response status line and headers in body output")
+	output := fs.String("o", "body", "output format: body, json, headers or status")
+	fail := fs.Bool("fail", false, fmt.Sprintf("exit with %d when the response status is 400 or above", exitHTTPError))
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	switch *output {
+	case "body", "json", "headers", "status":
+	default:
+		fmt.Fprintf(stderr, "unknown output format %q\n", *output)
+		return exitUsage
+	}
+	if c.verbose {
+		log.Logger = log.Logger.Level(zerolog.WarnLevel)
+	}
+
+	client, err := c.client()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	body, err := c.body(stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	req, err := c.newRequest(ctx, fs.Arg(0), body)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if c.verbose {
+		fmt.Fprintf(stderr, "> %s %s\n", req.Method, common.RedactURL(req.URL))
+		writeHeaders(stderr, "> ", common.RedactHeaders(req.Header))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	defer resp.Body.Close()
+	if c.verbose {
+		fmt.Fprintf(stderr, "< %s %s\n", resp.Proto, resp.Status)
+		writeHeaders(stderr, "< ", common.RedactHeaders(resp.Header))
+	}
+
+	if err := writeResponse(stdout, resp, *output, *include); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if *fail && resp.StatusCode >= 400 {
+		return exitHTTPError
+	}
+	return exitOK
+}
+
+func writeResponse(w io.Writer, resp *http.Response, format string, include bool) error {
+	switch format {
+	case "status":
+		_, err := fmt.Fprintln(w, resp.StatusCode)
+		return err
+	case "headers":
+		fmt.Fprintf(w, "%s %s\n", resp.Proto, resp.Status)
+		writeHeaders(w, "", resp.Header)
+		return nil
+	case "json":
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		out := struct {
+			Status  int             `json:"status"`
+			Headers http.Header     `json:"headers"`
+			Body    json.RawMessage `json:"body,omitempty"`
+		}{Status: resp.StatusCode, Headers: resp.Header}
+		if json.Valid(body) {
+			out.Body = body
+		} else if len(body) > 0 {
+			out.Body, _ = json.Marshal(string(body))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "body":
+		if include {
+			fmt.Fprintf(w, "%s %s\n", resp.Proto, resp.Status)
+			writeHeaders(w, "", resp.Header)
+			fmt.Fprintln(w)
+		}
+		_, err := io.Copy(w, resp.Body)
+		return err
+	default:
+		return errors.New("unknown output format " + format)
+	}
+}
+
+func writeHeaders(w io.Writer, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range header[name] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, v)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunRequestRejectsUnknownOutputBeforeSending(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-X", "DELETE", "-o", "xml", srv.URL}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitUsage {
+		t.Fatalf("exit code = %d, want %d", code, exitUsage)
+	}
+	if hits != 0 {
+		t.Fatalf("request sent %d times despite invalid -o", hits)
+	}
+	if !strings.Contains(stderr.String(), "unknown output format") {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveTimeout derives each attempt's timeout for response headers from
+// the host's observed time to headers: the configured percentile times
+// Factor, clamped to [Min, Max]. Reading the body is bounded only by the
+// client timeout. Until MinSamples attempts have been observed the client
+// timeout applies.
+type AdaptiveTimeout struct {
+	// Percentile defaults to 0.99.
+	Percentile float64
+	// Factor defaults to 3.
+	Factor float64
+	// Min defaults to 100ms.
+	Min time.Duration
+	// Max defaults to the client timeout.
+	Max time.Duration
+	// MinSamples defaults to 20.
+	MinSamples float64
+}
+
+// WithAdaptiveTimeout enables WithStats, whose latency samples it draws on.
+func WithAdaptiveTimeout(a AdaptiveTimeout) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if a.Percentile <= 0 || a.Percentile > 1 {
+			a.Percentile = 0.99
+		}
+		if a.Factor <= 0 {
+			a.Factor = 3
+		}
+		if a.Min <= 0 {
+			a.Min = 100 * time.Millisecond
+		}
+		if a.MinSamples <= 0 {
+			a.MinSamples = 20
+		}
+		h.adaptive = &a
+		h.statsRegistry()
+	}
+}
+
+// attemptTimeout returns the adaptive timeout for an attempt against host, or
+// zero when the client timeout should apply.
+func (h *HTTPRetry) attemptTimeout(host string, client *http.Client) time.Duration {
+	if h.adaptive == nil {
+		return 0
+	}
+	latency, samples := h.stats.quantile(host, h.adaptive.Percentile)
+	if samples < h.adaptive.MinSamples {
+		return 0
+	}
+	timeout := time.Duration(float64(latency) * h.adaptive.Factor)
+	upper := h.adaptive.Max
+	if upper <= 0 {
+		upper = client.Timeout
+	}
+	if upper > 0 && timeout > upper {
+		timeout = upper
+	}
+	if timeout < h.adaptive.Min {
+		timeout = h.adaptive.Min
+	}
+	return timeout
+}
+
+// headerTimeout cancels an attempt whose response headers have not arrived
+// within the adaptive timeout. Latency samples only cover the time to
+// headers, so the body is not held to the same limit.
+type headerTimeout struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func startHeaderTimeout(timeout time.Duration, cancel context.CancelFunc) *headerTimeout {
+	t := &headerTimeout{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.fired.Store(true)
+		cancel()
+	})
+	return t
+}
+
+// stop disarms the timeout once headers arrived or the attempt failed and
+// reports err as a deadline error if the timeout caused it.
+func (t *headerTimeout) stop(err error) error {
+	if t == nil {
+		return err
+	}
+	t.timer.Stop()
+	if err != nil && t.fired.Load() {
+		return fmt.Errorf("no response headers within adaptive timeout of %s: %w", t.timeout, context.DeadlineExceeded)
+	}
+	return err
+}
+
+func (r *statsRegistry) quantile(host string, q float64) (time.Duration, float64) {
+	if r == nil {
+		return 0, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.hosts[host]
+	if !ok {
+		return 0, 0
+	}
+	s.latency.decay(time.Now())
+	return s.latency.quantile(q), s.latency.total
+}
+
+// cancelBody releases an attempt's context once the caller is done with the
+// response body.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.cancel)
+	return err
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveTimeoutCoversHeadersOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-body":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, "done")
+		case "/slow-headers":
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithRetries(1), WithAdaptiveTimeout(AdaptiveTimeout{}))
+	get := func(path string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		return h.Do(req)
+	}
+	for i := 0; i < 20; i++ {
+		resp, err := get("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := get("/slow-body")
+	if err != nil {
+		t.Fatalf("slow body: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "done" {
+		t.Fatalf("slow body read %q, %v", body, err)
+	}
+
+	if _, err := get("/slow-headers"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow headers error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestAdaptiveTimeoutExcludesGateWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithRetries(1), WithMaxConcurrency(1), WithAdaptiveTimeout(AdaptiveTimeout{}))
+	for i := 0; i < 20; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := h.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	held, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(300*time.Millisecond, func() { held.Body.Close() })
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := h.Do(req)
+	if err != nil {
+		t.Fatalf("request queued behind the concurrency limit: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestAdaptiveTimeoutIgnoresFailedAttempts(t *testing.T) {
+	h := NewHTTPRetry(WithAdaptiveTimeout(AdaptiveTimeout{}))
+	u, _ := url.Parse("http://service.example")
+	for i := 0; i < 20; i++ {
+		h.stats.attempt(u.Host, u, 10*time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	before := h.attemptTimeout(u.Host, h.HTTPClient)
+	for i := 0; i < 100; i++ {
+		h.stats.attempt(u.Host, u, 5*time.Second, nil, context.DeadlineExceeded)
+		h.stats.attempt(u.Host, u, time.Microsecond, nil, errors.New("connection refused"))
+	}
+	if after := h.attemptTimeout(u.Host, h.HTTPClient); after != before {
+		t.Fatalf("timeout moved from %s to %s after failed attempts", before, after)
+	}
+}
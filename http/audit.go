@@ -0,0 +1,293 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditRecord describes one call to Do. URL is redacted with RedactURL.
+// BytesSent counts request body bytes across all attempts; BytesReceived
+// counts the response body bytes the caller read.
+type AuditRecord struct {
+	Time          time.Time     `json:"time"`
+	Caller        string        `json:"caller,omitempty"`
+	Method        string        `json:"method"`
+	URL           string        `json:"url"`
+	Status        int           `json:"status,omitempty"`
+	Attempts      int           `json:"attempts"`
+	BytesSent     int64         `json:"bytesSent"`
+	BytesReceived int64         `json:"bytesReceived"`
+	Duration      time.Duration `json:"duration"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// AuditSink receives audit records from a single goroutine.
+type AuditSink interface {
+	WriteAudit(record AuditRecord) error
+	Close() error
+}
+
+// Audit configures the audit trail written by Do. Records are queued and
+// written to Sink by a background goroutine; Close flushes the queue.
+type Audit struct {
+	Sink AuditSink
+	// Buffer is the number of records queued for the sink and defaults to
+	// 1024.
+	Buffer int
+	// Block makes Do wait for room in a full queue. By default records that
+	// do not fit are dropped and the number dropped is logged.
+	Block bool
+}
+
+// WithAudit records every call to Do. A response's record is written once
+// its body is closed, so that the bytes received are known.
+func WithAudit(a Audit) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if a.Buffer <= 0 {
+			a.Buffer = 1024
+		}
+		h.audit = newAuditor(h, a)
+	}
+}
+
+type auditIdentityKey struct{}
+
+// ContextWithAuditIdentity sets the caller recorded in the audit trail for
+// requests carrying the returned context.
+func ContextWithAuditIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, auditIdentityKey{}, identity)
+}
+
+type auditor struct {
+	h       *HTTPRetry
+	config  Audit
+	records chan AuditRecord
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+	err    error
+}
+
+func newAuditor(h *HTTPRetry, a Audit) *auditor {
+	au := &auditor{h: h, config: a, records: make(chan AuditRecord, a.Buffer), done: make(chan struct{})}
+	go au.run()
+	return au
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+	for r := range a.records {
+		if err := a.config.Sink.WriteAudit(r); err != nil {
+			a.h.logger(context.Background()).Warn().Fields(map[string]interface{}{"err": err}).Msg("Unable to write audit record")
+		}
+		if n := a.dropped.Swap(0); n > 0 {
+			a.h.logger(context.Background()).Warn().Fields(map[string]interface{}{"dropped": n}).Msg("Dropped audit records")
+		}
+	}
+}
+
+func (a *auditor) record(r AuditRecord) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	if a.config.Block {
+		a.records <- r
+		return
+	}
+	select {
+	case a.records <- r:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+func (a *auditor) close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return a.err
+	}
+	a.closed = true
+	close(a.records)
+	a.mu.Unlock()
+
+	<-a.done
+	if n := a.dropped.Swap(0); n > 0 {
+		a.h.logger(context.Background()).Warn().Fields(map[string]interface{}{"dropped": n}).Msg("Dropped audit records")
+	}
+	a.err = a.config.Sink.Close()
+	return a.err
+}
+
+// audited wraps do with an audit record. Request body bytes are counted by
+// do as each attempt is sent, through a counter carried by the context, so
+// reads for logging or dumps are left out.
+func (h *HTTPRetry) audited(req *http.Request) (*http.Response, int, error) {
+	start := time.Now()
+	r := AuditRecord{Time: start, Method: req.Method, URL: RedactURL(req.URL)}
+	if id, ok := req.Context().Value(auditIdentityKey{}).(string); ok {
+		r.Caller = id
+	}
+
+	sent := new(atomic.Int64)
+	resp, attempts, err := h.do(req.WithContext(context.WithValue(req.Context(), auditSentKey{}, sent)))
+
+	r.Attempts = attempts
+	r.BytesSent = sent.Load()
+	if err != nil {
+		r.Duration = time.Since(start)
+		r.Error = err.Error()
+		h.audit.record(r)
+		return nil, attempts, err
+	}
+	r.Status = resp.StatusCode
+	resp.Body = &auditBody{countingBody: countingBody{ReadCloser: resp.Body, n: new(atomic.Int64)}, finish: func(received int64) {
+		r.BytesReceived = received
+		r.Duration = time.Since(start)
+		h.audit.record(r)
+	}}
+	return resp, attempts, nil
+}
+
+type auditSentKey struct{}
+
+// countSent makes the bytes the transport reads from attempt's body count
+// towards the audit record of the request, if it has one.
+func countSent(attempt *http.Request) {
+	sent, ok := attempt.Context().Value(auditSentKey{}).(*atomic.Int64)
+	if !ok || attempt.Body == nil {
+		return
+	}
+	attempt.Body = &countingBody{ReadCloser: attempt.Body, n: sent}
+	if getBody := attempt.GetBody; getBody != nil {
+		attempt.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return &countingBody{ReadCloser: body, n: sent}, nil
+		}
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+type auditBody struct {
+	countingBody
+	once   sync.Once
+	finish func(received int64)
+}
+
+func (b *auditBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.finish(b.n.Load()) })
+	return err
+}
+
+// ChannelAuditSink sends records to a channel it does not own; Close leaves
+// the channel open.
+type ChannelAuditSink chan<- AuditRecord
+
+func (c ChannelAuditSink) WriteAudit(r AuditRecord) error {
+	c <- r
+	return nil
+}
+
+func (c ChannelAuditSink) Close() error { return nil }
+
+// FileAuditSink writes records as JSON lines, rotating the file once it
+// would grow beyond MaxSize. Rotated files are named path.1 (newest) up to
+// path.MaxBackups; older ones are removed.
+type FileAuditSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// NewFileAuditSink opens path for appending. A maxSize of zero disables
+// rotation.
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	s := &FileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileAuditSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileAuditSink) WriteAudit(r AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
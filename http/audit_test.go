@@ -0,0 +1,39 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAuditCountsOnlyBytesSent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	records := make(chan AuditRecord, 1)
+	h := NewHTTPRetry(
+		WithRetries(2),
+		WithBackoff(0),
+		WithLogger(zerolog.New(io.Discard).Level(zerolog.DebugLevel)),
+		WithBodyLogging(BodyLogging{}),
+		WithAudit(Audit{Sink: ChannelAuditSink(records)}),
+	)
+	h.sleep.Sleeper = noSleep{}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	if _, err := h.Do(req); err == nil {
+		t.Fatal("expected retries to be exhausted")
+	}
+
+	r := <-records
+	if r.Attempts != 2 || r.BytesSent != 10 {
+		t.Fatalf("record = %+v, want 2 attempts and 10 bytes sent", r)
+	}
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// BackoffStrategy returns how long to wait after the zero-based failed
+// attempt, given the client's Backoff in seconds.
+type BackoffStrategy func(attempt, backoff int) time.Duration
+
+// LinearBackoff waits attempt*backoff seconds. It is the default.
+func LinearBackoff(attempt, backoff int) time.Duration {
+	return time.Duration(attempt*backoff) * time.Second
+}
+
+// ExponentialBackoff waits backoff seconds, doubling after every attempt. Waits
+// too long for a time.Duration are clamped to the largest one.
+func ExponentialBackoff(attempt, backoff int) time.Duration {
+	const maxWait = time.Duration(math.MaxInt64)
+	if attempt > 30 {
+		attempt = 30
+	}
+	if backoff <= 0 {
+		return ConstantBackoff(attempt, backoff)
+	}
+	if int64(backoff) > int64(maxWait>>attempt/time.Second) {
+		return maxWait
+	}
+	return time.Duration(backoff) * time.Second << attempt
+}
+
+// ConstantBackoff waits backoff seconds after every attempt.
+func ConstantBackoff(_, backoff int) time.Duration {
+	return time.Duration(backoff) * time.Second
+}
+
+func WithBackoffStrategy(strategy BackoffStrategy) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.backoffStrategy = strategy
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy BackoffStrategy
+		attempt  int
+		backoff  int
+		want     time.Duration
+	}{
+		{name: "linear", strategy: LinearBackoff, attempt: 2, backoff: 3, want: 6 * time.Second},
+		{name: "constant", strategy: ConstantBackoff, attempt: 5, backoff: 3, want: 3 * time.Second},
+		{name: "exponential first", strategy: ExponentialBackoff, attempt: 0, backoff: 2, want: 2 * time.Second},
+		{name: "exponential doubles", strategy: ExponentialBackoff, attempt: 3, backoff: 2, want: 16 * time.Second},
+		{name: "exponential zero", strategy: ExponentialBackoff, attempt: 40, backoff: 0, want: 0},
+		{name: "exponential clamped attempt", strategy: ExponentialBackoff, attempt: 100, backoff: 1, want: time.Second << 30},
+		{name: "exponential overflow", strategy: ExponentialBackoff, attempt: 30, backoff: 10, want: time.Duration(math.MaxInt64)},
+		{name: "exponential huge base", strategy: ExponentialBackoff, attempt: 0, backoff: math.MaxInt32, want: math.MaxInt32 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.strategy(tt.attempt, tt.backoff); got != tt.want {
+			t.Errorf("%s(%d, %d) = %s, want %s", tt.name, tt.attempt, tt.backoff, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrBatcherClosed = errors.New("batcher closed")
+
+// BatchResult is the outcome for one item of a batch.
+type BatchResult[V any] struct {
+	Value V
+	Err   error
+}
+
+// BatchConfig describes how a Batcher combines items into batch calls.
+type BatchConfig[K, V any] struct {
+	// MaxSize is the largest number of items sent in one call and defaults
+	// to 100.
+	MaxSize int
+	// MaxWait is how long the first item of a batch waits for others and
+	// defaults to 10ms.
+	MaxWait time.Duration
+	// NewRequest builds the batch call for keys.
+	NewRequest func(ctx context.Context, keys []K) (*http.Request, error)
+	// Decode turns a successful batch response into one result per key, in
+	// the order of keys. The response body is closed afterwards.
+	Decode func(resp *http.Response, keys []K) ([]BatchResult[V], error)
+}
+
+// Batcher collects individual lookups into batch calls sent through
+// HTTPRetry. A batch is sent once it holds MaxSize items or its first item
+// has waited MaxWait. Failures of the whole call are returned to every item
+// in it.
+type Batcher[K, V any] struct {
+	h      *HTTPRetry
+	config BatchConfig[K, V]
+
+	mu      sync.Mutex
+	pending []*batchItem[K, V]
+	timer   *time.Timer
+	closed  bool
+	running sync.WaitGroup
+}
+
+type batchItem[K, V any] struct {
+	key    K
+	result chan BatchResult[V]
+}
+
+func NewBatcher[K, V any](h *HTTPRetry, config BatchConfig[K, V]) *Batcher[K, V] {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 100
+	}
+	if config.MaxWait <= 0 {
+		config.MaxWait = 10 * time.Millisecond
+	}
+	return &Batcher[K, V]{h: h, config: config}
+}
+
+// Do adds key to the next batch and waits for its result. Returning early
+// because ctx is done does not remove key from the batch.
+func (b *Batcher[K, V]) Do(ctx context.Context, key K) (V, error) {
+	item := &batchItem[K, V]{key: key, result: make(chan BatchResult[V], 1)}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		var zero V
+		return zero, ErrBatcherClosed
+	}
+	b.pending = append(b.pending, item)
+	switch {
+	case len(b.pending) >= b.config.MaxSize:
+		b.flushLocked()
+	case len(b.pending) == 1:
+		b.timer = time.AfterFunc(b.config.MaxWait, b.flush)
+	}
+	b.mu.Unlock()
+
+	select {
+	case r := <-item.result:
+		return r.Value, r.Err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Close sends any pending items and waits for batches in flight. Later
+// calls to Do fail with ErrBatcherClosed.
+func (b *Batcher[K, V]) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.flushLocked()
+	b.mu.Unlock()
+	b.running.Wait()
+}
+
+func (b *Batcher[K, V]) flush() {
+	b.mu.Lock()
+	b.flushLocked()
+	b.mu.Unlock()
+}
+
+func (b *Batcher[K, V]) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	items := b.pending
+	b.pending = nil
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		b.send(items)
+	}()
+}
+
+func (b *Batcher[K, V]) send(items []*batchItem[K, V]) {
+	keys := make([]K, len(items))
+	for i, item := range items {
+		keys[i] = item.key
+	}
+	results, err := b.call(keys)
+	if err == nil && len(results) != len(items) {
+		err = fmt.Errorf("batch response has %d results for %d items", len(results), len(items))
+	}
+	for i, item := range items {
+		if err != nil {
+			item.result <- BatchResult[V]{Err: err}
+		} else {
+			item.result <- results[i]
+		}
+	}
+}
+
+// call runs detached from any one caller's context, since the batch serves
+// several of them.
+func (b *Batcher[K, V]) call(keys []K) ([]BatchResult[V], error) {
+	req, err := b.config.NewRequest(context.Background(), keys)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.h.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, newStatusError(resp)
+	}
+	return b.config.Decode(resp, keys)
+}
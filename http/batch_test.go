@@ -0,0 +1,204 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchServer answers a JSON array of keys with each key times ten, or with
+// status when it is set.
+type batchServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches [][]int
+	status  int
+}
+
+func newBatchServer(t *testing.T) *batchServer {
+	s := &batchServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var keys []int
+		json.NewDecoder(r.Body).Decode(&keys)
+		s.mu.Lock()
+		s.batches = append(s.batches, keys)
+		status := s.status
+		s.mu.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		values := make([]int, len(keys))
+		for i, k := range keys {
+			values[i] = k * 10
+		}
+		json.NewEncoder(w).Encode(values)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *batchServer) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+func (s *batchServer) config(maxSize int, maxWait time.Duration) BatchConfig[int, int] {
+	return BatchConfig[int, int]{
+		MaxSize: maxSize,
+		MaxWait: maxWait,
+		NewRequest: func(ctx context.Context, keys []int) (*http.Request, error) {
+			body, _ := json.Marshal(keys)
+			return http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+		},
+		Decode: func(resp *http.Response, keys []int) ([]BatchResult[int], error) {
+			var values []int
+			if err := json.NewDecoder(resp.Body).Decode(&values); err != nil {
+				return nil, err
+			}
+			results := make([]BatchResult[int], len(values))
+			for i, v := range values {
+				if keys[i] < 0 {
+					results[i].Err = fmt.Errorf("negative key %d", keys[i])
+				} else {
+					results[i].Value = v
+				}
+			}
+			return results, nil
+		},
+	}
+}
+
+// doAll looks up keys concurrently and returns the results by key.
+func doAll(b *Batcher[int, int], keys ...int) map[int]BatchResult[int] {
+	var mu sync.Mutex
+	out := map[int]BatchResult[int]{}
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			v, err := b.Do(context.Background(), k)
+			mu.Lock()
+			out[k] = BatchResult[int]{Value: v, Err: err}
+			mu.Unlock()
+		}(k)
+	}
+	wg.Wait()
+	return out
+}
+
+func TestBatcherFlushes(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int
+		maxWait   time.Duration
+		keys      []int
+		wantSizes []int
+	}{
+		{name: "max size", maxSize: 3, maxWait: time.Hour, keys: []int{1, 2, 3, 4, 5, 6}, wantSizes: []int{3, 3}},
+		{name: "max wait", maxSize: 100, maxWait: 20 * time.Millisecond, keys: []int{1, 2, 3}, wantSizes: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newBatchServer(t)
+			b := NewBatcher(NewHTTPRetry(), srv.config(tt.maxSize, tt.maxWait))
+			defer b.Close()
+
+			results := doAll(b, tt.keys...)
+			for _, k := range tt.keys {
+				if r := results[k]; r.Err != nil || r.Value != k*10 {
+					t.Errorf("result for %d = %+v, want %d", k, r, k*10)
+				}
+			}
+			if got := srv.sizes(); fmt.Sprint(got) != fmt.Sprint(tt.wantSizes) {
+				t.Fatalf("batch sizes = %v, want %v", got, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestBatcherPerKeyErrors(t *testing.T) {
+	srv := newBatchServer(t)
+	b := NewBatcher(NewHTTPRetry(), srv.config(2, time.Hour))
+	defer b.Close()
+
+	results := doAll(b, 4, -1)
+	if r := results[4]; r.Err != nil || r.Value != 40 {
+		t.Errorf("result for 4 = %+v", r)
+	}
+	if r := results[-1]; r.Err == nil {
+		t.Errorf("result for -1 = %+v, want an error", r)
+	}
+}
+
+func TestBatcherWholeCallFailures(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		srv := newBatchServer(t)
+		srv.status = http.StatusBadRequest
+		b := NewBatcher(NewHTTPRetry(), srv.config(3, time.Hour))
+		defer b.Close()
+		for k, r := range doAll(b, 1, 2, 3) {
+			if !errors.Is(r.Err, ErrUnexpectedStatus) {
+				t.Errorf("result for %d = %+v, want ErrUnexpectedStatus", k, r)
+			}
+		}
+	})
+	t.Run("result count", func(t *testing.T) {
+		srv := newBatchServer(t)
+		config := srv.config(2, time.Hour)
+		decode := config.Decode
+		config.Decode = func(resp *http.Response, keys []int) ([]BatchResult[int], error) {
+			results, err := decode(resp, keys)
+			return results[:1], err
+		}
+		b := NewBatcher(NewHTTPRetry(), config)
+		defer b.Close()
+		for k, r := range doAll(b, 1, 2) {
+			if r.Err == nil {
+				t.Errorf("result for %d = %+v, want a count mismatch error", k, r)
+			}
+		}
+	})
+}
+
+func TestBatcherClose(t *testing.T) {
+	srv := newBatchServer(t)
+	b := NewBatcher(NewHTTPRetry(), srv.config(100, time.Hour))
+
+	done := make(chan BatchResult[int])
+	go func() {
+		v, err := b.Do(context.Background(), 7)
+		done <- BatchResult[int]{Value: v, Err: err}
+	}()
+	for {
+		b.mu.Lock()
+		pending := len(b.pending)
+		b.mu.Unlock()
+		if pending == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.Close()
+	if r := <-done; r.Err != nil || r.Value != 70 {
+		t.Fatalf("pending item = %+v, want it sent on Close", r)
+	}
+	if _, err := b.Do(context.Background(), 8); !errors.Is(err, ErrBatcherClosed) {
+		t.Fatalf("Do after Close = %v, want ErrBatcherClosed", err)
+	}
+}
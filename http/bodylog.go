@@ -0,0 +1,268 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// BodyLogging logs request and response bodies at debug level. Bodies are
+// only read for logging when the request's logger has debug enabled.
+type BodyLogging struct {
+	// MaxSize is the number of bytes logged per body and defaults to 4 KiB.
+	// Longer bodies are truncated.
+	MaxSize int
+	// ContentTypes are the media types logged. An entry ending in "/" matches
+	// a whole type such as "text/", one starting with "+" a structured syntax
+	// suffix such as "+json". Defaults to textual types; bodies without a
+	// Content-Type or with a Content-Encoding are never logged.
+	ContentTypes []string
+	// Redactions are applied in order before logging. Defaults to
+	// DefaultBodyRedactions.
+	Redactions []BodyRedaction
+	// PublicKey, when set, encrypts logged bodies so that only the holder of
+	// the private key can read them with DecryptLoggedBody.
+	PublicKey *rsa.PublicKey
+}
+
+// BodyRedaction replaces matches of Pattern with Replacement, which may refer
+// to submatches as in regexp.Regexp.ReplaceAll.
+type BodyRedaction struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+var defaultBodyContentTypes = []string{
+	"text/", "application/json", "application/xml", "application/x-www-form-urlencoded", "+json", "+xml",
+}
+
+// DefaultBodyRedactions hide the values of JSON fields and form parameters
+// named like the query parameters RedactURL hides, including a JSON value cut
+// off by the end of the logged buffer.
+var DefaultBodyRedactions = []BodyRedaction{
+	{
+		Pattern:     regexp.MustCompile(`(?i)("(?:` + strings.Join(sensitiveParams, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|\\?$)`),
+		Replacement: `${1}"` + redacted + `"`,
+	},
+	{
+		Pattern:     regexp.MustCompile(`(?i)((?:^|&)(?:` + strings.Join(sensitiveParams, "|") + `)=)[^&]*`),
+		Replacement: "${1}" + redacted,
+	},
+}
+
+func WithBodyLogging(b BodyLogging) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if b.MaxSize <= 0 {
+			b.MaxSize = 4 << 10
+		}
+		if b.ContentTypes == nil {
+			b.ContentTypes = defaultBodyContentTypes
+		}
+		if b.Redactions == nil {
+			b.Redactions = DefaultBodyRedactions
+		}
+		h.bodyLog = &b
+	}
+}
+
+func (b *BodyLogging) loggable(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range b.ContentTypes {
+		switch {
+		case strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t),
+			strings.HasPrefix(t, "+") && strings.HasSuffix(mediaType, t),
+			mediaType == t:
+			return true
+		}
+	}
+	return false
+}
+
+// buffered is the number of bytes read for logging. It reaches past MaxSize
+// so that redactions see values that straddle the cut.
+func (b *BodyLogging) buffered() int {
+	return 2 * b.MaxSize
+}
+
+// log writes body, of which the first len(body) of total bytes were read, to
+// logger. Redactions run on all of body before it is cut to MaxSize.
+func (b *BodyLogging) log(logger *zerolog.Logger, msg string, fields map[string]interface{}, body []byte, total int64) {
+	truncated := len(body) > b.MaxSize || total > int64(len(body))
+	for _, r := range b.Redactions {
+		body = r.Pattern.ReplaceAll(body, []byte(r.Replacement))
+	}
+	if len(body) > b.MaxSize {
+		body = body[:b.MaxSize]
+	}
+	fields["truncated"] = truncated
+	if b.PublicKey == nil {
+		fields["body"] = string(body)
+	} else if enc, err := encryptBody(b.PublicKey, body); err == nil {
+		fields["bodyEncrypted"] = enc
+	} else {
+		fields["bodyEncryptionError"] = err.Error()
+	}
+	logger.Debug().Fields(fields).Msg(msg)
+}
+
+func (h *HTTPRetry) logRequestBody(logger *zerolog.Logger, req *http.Request, getBody func() (io.ReadCloser, error)) {
+	if h.bodyLog == nil || getBody == nil || !debugEnabled(logger) || !h.bodyLog.loggable(req.Header) {
+		return
+	}
+	body, err := getBody()
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	buf, _ := io.ReadAll(io.LimitReader(body, int64(h.bodyLog.buffered())))
+	h.bodyLog.log(logger, "Http request body", map[string]interface{}{
+		"method": req.Method,
+		"URL":    RedactURL(req.URL),
+	}, buf, req.ContentLength)
+}
+
+// logFailedResponseBody logs the start of a response that is about to be
+// discarded before a retry.
+func (h *HTTPRetry) logFailedResponseBody(logger *zerolog.Logger, resp *http.Response) {
+	if h.bodyLog == nil || !debugEnabled(logger) || !h.bodyLog.loggable(resp.Header) {
+		return
+	}
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, int64(h.bodyLog.buffered())))
+	h.bodyLog.log(logger, "Http response body", responseBodyFields(resp), buf, resp.ContentLength)
+}
+
+// logResponseBody arranges for the part of resp's body the caller reads to
+// be logged once the body is closed.
+func (h *HTTPRetry) logResponseBody(logger *zerolog.Logger, resp *http.Response) {
+	if h.bodyLog == nil || !debugEnabled(logger) || !h.bodyLog.loggable(resp.Header) {
+		return
+	}
+	fields := responseBodyFields(resp)
+	resp.Body = &loggedBody{ReadCloser: resp.Body, limit: h.bodyLog.buffered(), finish: func(buf []byte, total int64) {
+		h.bodyLog.log(logger, "Http response body", fields, buf, total)
+	}}
+}
+
+func debugEnabled(logger *zerolog.Logger) bool {
+	return logger.GetLevel() <= zerolog.DebugLevel && zerolog.GlobalLevel() <= zerolog.DebugLevel
+}
+
+func responseBodyFields(resp *http.Response) map[string]interface{} {
+	fields := map[string]interface{}{"responseStatusCode": resp.StatusCode}
+	if resp.Request != nil {
+		fields["method"] = resp.Request.Method
+		fields["URL"] = RedactURL(resp.Request.URL)
+	}
+	return fields
+}
+
+type loggedBody struct {
+	io.ReadCloser
+	limit  int
+	buf    bytes.Buffer
+	total  int64
+	once   sync.Once
+	finish func(buf []byte, total int64)
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.buf.Write(p[:room])
+	}
+	b.total += int64(n)
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.finish(b.buf.Bytes(), b.total) })
+	return err
+}
+
+// Encrypted bodies are base64 of a big-endian uint16 key length, the
+// AES-256 key encrypted with RSA-OAEP (SHA-256), a GCM nonce and the sealed
+// body.
+func encryptBody(pub *rsa.PublicKey, body []byte) (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(encKey)))
+	out = append(out, encKey...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, body, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// DecryptLoggedBody decrypts a bodyEncrypted log field.
+func DecryptLoggedBody(priv *rsa.PrivateKey, encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 {
+		return nil, errors.New("encrypted body too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < n {
+		return nil, errors.New("encrypted body too short")
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, data[:n], nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[n:]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted body too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestBodyLoggingRedactsAcrossCut(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out).Level(zerolog.DebugLevel)
+	b := &BodyLogging{MaxSize: 20, Redactions: DefaultBodyRedactions}
+	body := []byte(`{"password":"supersecretvalue","user":"x"}`)
+	if len(body) > b.buffered() {
+		body = body[:b.buffered()]
+	}
+	b.log(&logger, "body", map[string]interface{}{}, body, 42)
+
+	if strings.Contains(out.String(), "supers") {
+		t.Fatalf("secret leaked across the cut: %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"truncated":true`) {
+		t.Fatalf("body not marked truncated: %s", out.String())
+	}
+}
+
+func TestBodyLoggingRedactsValueCutByBuffer(t *testing.T) {
+	for _, body := range []string{
+		`{"user":"x","password":"SSSSSSSSSSSSSSSSSSSS`,
+		`{"user":"x","password":"SSSSSSSSSS\`,
+	} {
+		var out bytes.Buffer
+		logger := zerolog.New(&out).Level(zerolog.DebugLevel)
+		b := &BodyLogging{MaxSize: 64, Redactions: DefaultBodyRedactions}
+		b.log(&logger, "body", map[string]interface{}{}, []byte(body), 1000)
+		if strings.Contains(out.String(), "SSSS") {
+			t.Fatalf("partial secret leaked: %s", out.String())
+		}
+	}
+}
+
+func TestRetriesExhaustedLogIsRedacted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	h := NewHTTPRetry(WithRetries(1), WithLogger(zerolog.New(&out).Level(zerolog.DebugLevel)), WithBodyLogging(BodyLogging{}))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("password=hunter2"))
+	req.Header.Set("Authorization", "Bearer topsecret")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := h.Do(req); err == nil {
+		t.Fatal("expected retries to be exhausted")
+	}
+	if !strings.Contains(out.String(), "Max retry limit") {
+		t.Fatalf("exhaustion not logged: %s", out.String())
+	}
+	if strings.Contains(out.String(), "topsecret") || strings.Contains(out.String(), "hunter2") {
+		t.Fatalf("secret logged: %s", out.String())
+	}
+}
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ChaosRule describes a fault injected into requests whose host matches Hosts.
+// Every fault configured on a rule is applied when the rule fires.
+type ChaosRule struct {
+	// Hosts restricts the rule to these hosts. "*.example.com" matches any
+	// subdomain. An empty list matches every host.
+	Hosts       []string
+	Probability float64
+
+	Latency       time.Duration
+	LatencyJitter time.Duration
+
+	// Error is returned instead of performing the request.
+	Error error
+	// StatusCode, when non-zero, answers the request with a synthesized response.
+	StatusCode int
+	// DropConnection fails the request with a connection reset.
+	DropConnection bool
+	// SlowBodyBytesPerSecond throttles reads from the real response body.
+	SlowBodyBytesPerSecond int
+}
+
+// ChaosTransport is an http.RoundTripper that injects faults for resilience testing.
+type ChaosTransport struct {
+	Base http.RoundTripper
+
+	enabled atomic.Bool
+	mu      sync.Mutex
+	rules   []ChaosRule
+	rand    *rand.Rand
+}
+
+func NewChaosTransport(base http.RoundTripper, rules ...ChaosRule) *ChaosTransport {
+	c := &ChaosTransport{
+		Base:  base,
+		rules: rules,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec // not used for security
+	}
+	c.enabled.Store(true)
+	return c
+}
+
+func WithChaos(c *ChaosTransport) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if c.Base == nil {
+			c.Base = h.HTTPClient.Transport
+		}
+		h.HTTPClient.Transport = c
+	}
+}
+
+func (c *ChaosTransport) SetRules(rules ...ChaosRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append([]ChaosRule(nil), rules...)
+}
+
+func (c *ChaosTransport) Rules() []ChaosRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChaosRule(nil), c.rules...)
+}
+
+func (c *ChaosTransport) SetSeed(seed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rand = rand.New(rand.NewSource(seed)) //nolint:gosec // not used for security
+}
+
+func (c *ChaosTransport) Enable()  { c.enabled.Store(true) }
+func (c *ChaosTransport) Disable() { c.enabled.Store(false) }
+
+func (c *ChaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !c.enabled.Load() {
+		return c.base().RoundTrip(req)
+	}
+
+	var slowBody int
+	for _, rule := range c.fired(req.URL.Hostname()) {
+		if err := chaosDelay(req.Context(), rule.Latency, rule.jitter); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		switch {
+		case rule.Error != nil:
+			closeRequestBody(req)
+			return nil, rule.Error
+		case rule.DropConnection:
+			closeRequestBody(req)
+			return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+		case rule.StatusCode != 0:
+			closeRequestBody(req)
+			return chaosResponse(req, rule.StatusCode), nil
+		case rule.SlowBodyBytesPerSecond > 0:
+			slowBody = rule.SlowBodyBytesPerSecond
+		}
+	}
+
+	resp, err := c.base().RoundTrip(req)
+	if err != nil || slowBody == 0 {
+		return resp, err
+	}
+	resp.Body = &slowReader{ReadCloser: resp.Body, ctx: req.Context(), bytesPerSecond: slowBody}
+	return resp, nil
+}
+
+// closeRequestBody honours the RoundTripper contract on paths that never
+// reach the base transport.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func (c *ChaosTransport) base() http.RoundTripper {
+	if c.Base == nil {
+		return http.DefaultTransport
+	}
+	return c.Base
+}
+
+type firedRule struct {
+	ChaosRule
+	jitter time.Duration
+}
+
+func (c *ChaosTransport) fired(host string) []firedRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var fired []firedRule
+	for _, rule := range c.rules {
+		if !chaosHostMatches(rule.Hosts, host) || c.rand.Float64() >= rule.Probability {
+			continue
+		}
+		f := firedRule{ChaosRule: rule}
+		if rule.LatencyJitter > 0 {
+			f.jitter = time.Duration(c.rand.Int63n(int64(rule.LatencyJitter)))
+		}
+		fired = append(fired, f)
+	}
+	return fired
+}
+
+func chaosHostMatches(hosts []string, host string) bool {
+	if len(hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func chaosDelay(ctx context.Context, latency, jitter time.Duration) error {
+	d := latency + jitter
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func chaosResponse(req *http.Request, status int) *http.Response {
+	body := fmt.Sprintf("chaos: injected %d", status)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type slowReader struct {
+	io.ReadCloser
+	ctx            context.Context
+	bytesPerSecond int
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if len(p) > s.bytesPerSecond {
+		p = p[:s.bytesPerSecond]
+	}
+	n, err := s.ReadCloser.Read(p)
+	if n > 0 {
+		if delayErr := chaosDelay(s.ctx, time.Duration(n)*time.Second/time.Duration(s.bytesPerSecond), 0); delayErr != nil {
+			return n, delayErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChaosHostMatches(t *testing.T) {
+	tests := []struct {
+		hosts []string
+		host  string
+		want  bool
+	}{
+		{hosts: nil, host: "api.example.com", want: true},
+		{hosts: []string{"API.Example.com"}, host: "api.example.COM", want: true},
+		{hosts: []string{"*.example.com"}, host: "a.b.Example.com", want: true},
+		{hosts: []string{"*.example.com"}, host: "example.com", want: false},
+		{hosts: []string{"*.example.com"}, host: "badexample.com", want: false},
+		{hosts: []string{"other.com"}, host: "example.com", want: false},
+	}
+	for _, tt := range tests {
+		if got := chaosHostMatches(tt.hosts, tt.host); got != tt.want {
+			t.Errorf("chaosHostMatches(%q, %q) = %v, want %v", tt.hosts, tt.host, got, tt.want)
+		}
+	}
+}
+
+// closeRecorder reports whether the transport closed the request body.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestChaosTransport(t *testing.T) {
+	injected := errors.New("injected")
+	tests := []struct {
+		name       string
+		rule       ChaosRule
+		wantErr    error
+		wantStatus int
+		wantBase   bool
+	}{
+		{name: "error", rule: ChaosRule{Probability: 1, Error: injected}, wantErr: injected},
+		{name: "drop connection", rule: ChaosRule{Probability: 1, DropConnection: true}, wantErr: syscall.ECONNRESET},
+		{name: "status code", rule: ChaosRule{Probability: 1, StatusCode: http.StatusServiceUnavailable}, wantStatus: http.StatusServiceUnavailable},
+		{name: "other host", rule: ChaosRule{Hosts: []string{"other.com"}, Probability: 1, Error: injected}, wantStatus: http.StatusOK, wantBase: true},
+		{name: "never fires", rule: ChaosRule{Probability: 0, Error: injected}, wantStatus: http.StatusOK, wantBase: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calledBase := false
+			base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calledBase = true
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			})
+			c := NewChaosTransport(base, tt.rule)
+			body := &closeRecorder{Reader: strings.NewReader("payload")}
+			req, _ := http.NewRequest(http.MethodPost, "http://Example.com/", body)
+
+			resp, err := c.RoundTrip(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RoundTrip error = %v, want %v", err, tt.wantErr)
+			}
+			if calledBase != tt.wantBase {
+				t.Errorf("base transport called = %v, want %v", calledBase, tt.wantBase)
+			}
+			if !tt.wantBase && !body.closed {
+				t.Error("request body not closed on an injected failure")
+			}
+			if err == nil {
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestChaosTransportLatency(t *testing.T) {
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	c := NewChaosTransport(base, ChaosRule{Probability: 1, Latency: 30 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	start := time.Now()
+	resp, err := c.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("request took %s, want at least the injected 30ms", elapsed)
+	}
+}
+
+func TestChaosTransportDisable(t *testing.T) {
+	injected := errors.New("injected")
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	c := NewChaosTransport(base, ChaosRule{Probability: 1, Error: injected})
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	c.Disable()
+	resp, err := c.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip while disabled: %v", err)
+	}
+	resp.Body.Close()
+
+	c.Enable()
+	if _, err := c.RoundTrip(req); !errors.Is(err, injected) {
+		t.Fatalf("RoundTrip after Enable error = %v, want %v", err, injected)
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// CloneRequest returns a deep copy of req bound to ctx with a body that can be
+// read independently of req's. When req has a body but no GetBody, the body
+// is buffered and req.Body and req.GetBody are replaced so that req itself
+// stays readable; like Do, it must then not be called concurrently on req.
+func CloneRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	if err := replayable(req); err != nil {
+		return nil, err
+	}
+	return cloneWithBody(ctx, req, req.GetBody)
+}
+
+// replayable buffers req's body and sets GetBody when req has a body but no
+// GetBody.
+func replayable(req *http.Request) error {
+	getBody, err := bodyGetter(req)
+	if err != nil {
+		return err
+	}
+	if getBody != nil && req.GetBody == nil {
+		req.GetBody = getBody
+		if req.Body, err = getBody(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodyGetter returns a function producing fresh copies of req's body, reading
+// and closing req.Body when req has no GetBody. It returns nil for requests
+// without a body.
+func bodyGetter(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil //nolint:nilnil // a nil getter means no body
+	}
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+	bod, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bod)), nil
+	}, nil
+}
+
+func cloneWithBody(ctx context.Context, req *http.Request, getBody func() (io.ReadCloser, error)) (*http.Request, error) {
+	clone := req.Clone(ctx)
+	clone.Body, clone.GetBody = nil, getBody
+	if getBody != nil {
+		body, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
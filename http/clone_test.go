@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDoReusesRequestBody(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry()
+	// A reader NewRequest does not know how to rewind, so GetBody is nil.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, io.NopCloser(strings.NewReader("hello")))
+	for i := 0; i < 2; i++ {
+		resp, err := h.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(bodies) != 2 || bodies[0] != "hello" || bodies[1] != "hello" {
+		t.Errorf("server received %q, want the body twice", bodies)
+	}
+}
+
+type cloneKey struct{}
+
+func TestCloneRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://service.example", io.NopCloser(strings.NewReader("hello")))
+	req.Header.Set("X-Test", "a")
+	ctx := context.WithValue(context.Background(), cloneKey{}, "v")
+
+	clone, err := CloneRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Context().Value(cloneKey{}) != "v" {
+		t.Fatal("clone is not bound to ctx")
+	}
+	clone.Header.Set("X-Test", "b")
+	if req.Header.Get("X-Test") != "a" {
+		t.Fatal("clone shares headers with req")
+	}
+	if clone.GetBody == nil || req.GetBody == nil {
+		t.Fatal("GetBody not set")
+	}
+	for name, r := range map[string]func() (io.ReadCloser, error){
+		"clone body": func() (io.ReadCloser, error) { return clone.Body, nil },
+		"req body":   func() (io.ReadCloser, error) { return req.Body, nil },
+		"GetBody":    clone.GetBody,
+	} {
+		body, err := r()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(body); string(b) != "hello" {
+			t.Fatalf("%s = %q, want hello", name, b)
+		}
+	}
+}
+
+func TestDoConcurrentOnSharedRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := h.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if b, _ := io.ReadAll(resp.Body); string(b) != "hello" {
+				t.Errorf("echoed %q", b)
+			}
+		}()
+	}
+	wg.Wait()
+}
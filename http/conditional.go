@@ -0,0 +1,166 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnexpectedStatus   = errors.New("unexpected http response status")
+	ErrMissingETag        = errors.New("http response has no etag")
+	ErrWeakETag           = errors.New("http response has a weak etag, which If-Match cannot use")
+	ErrPreconditionFailed = errors.New("http precondition failed")
+)
+
+// StatusError reports a response whose status the caller did not expect.
+// Its body is closed.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnexpectedStatus || (target == ErrPreconditionFailed && e.StatusCode == http.StatusPreconditionFailed)
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.Request != nil {
+		e.Method, e.URL = resp.Request.Method, RedactURL(resp.Request.URL)
+	}
+	return e
+}
+
+// ConditionalUpdate describes an optimistic read-modify-write of a resource
+// guarded by its ETag.
+type ConditionalUpdate struct {
+	URL string
+	// Header is sent with both the read and the write, for example Accept
+	// and Content-Type.
+	Header http.Header
+	// Method defaults to PUT.
+	Method string
+	// MaxAttempts bounds the number of writes tried when the resource keeps
+	// changing underneath and defaults to 3.
+	MaxAttempts int
+	// Mutate receives the current body and returns the body to write.
+	// It is called again with the fresh body after each 412 Precondition
+	// Failed, so it must not depend on state from earlier calls.
+	Mutate func(current []byte) ([]byte, error)
+}
+
+// UpdateIfMatch GETs u.URL, applies u.Mutate and writes the result with
+// If-Match set to the ETag read, starting over when the write fails with
+// 412 Precondition Failed. It returns the successful write's response, or an
+// error matching ErrPreconditionFailed once MaxAttempts is used up. A
+// resource with only a weak ETag fails with ErrWeakETag before any write.
+func (h *HTTPRetry) UpdateIfMatch(ctx context.Context, u ConditionalUpdate) (*http.Response, error) {
+	if u.Method == "" {
+		u.Method = http.MethodPut
+	}
+	if u.MaxAttempts <= 0 {
+		u.MaxAttempts = 3
+	}
+	var failed error
+	for attempt := 0; attempt < u.MaxAttempts; attempt++ {
+		current, etag, err := h.getWithETag(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		next, err := u.Mutate(current)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, u.Method, u.URL, bytes.NewReader(next))
+		if err != nil {
+			return nil, err
+		}
+		copyHeader(req.Header, u.Header)
+		req.Header.Set("If-Match", etag)
+		resp, err := h.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			return nil, newStatusError(resp)
+		}
+		h.logger(ctx).Debug().Fields(map[string]interface{}{"URL": RedactURL(req.URL), "attempt": attempt}).Msg("Precondition failed, refetching")
+		failed = newStatusError(resp)
+	}
+	return nil, failed
+}
+
+func (h *HTTPRetry) getWithETag(ctx context.Context, u ConditionalUpdate) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	copyHeader(req.Header, u.Header)
+	resp, err := h.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, "", newStatusError(resp)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return nil, "", ErrMissingETag
+	}
+	// If-Match uses strong comparison, so a weak ETag never matches.
+	if strings.HasPrefix(etag, "W/") {
+		return nil, "", ErrWeakETag
+	}
+	body, err := io.ReadAll(resp.Body)
+	return body, etag, err
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+}
+
+// UpdateJSONIfMatch is UpdateIfMatch for JSON resources: mutate edits the
+// decoded resource in place. It returns the resource as written.
+func UpdateJSONIfMatch[T any](ctx context.Context, h *HTTPRetry, url string, maxAttempts int, mutate func(*T) error) (*T, error) {
+	var written T
+	resp, err := h.UpdateIfMatch(ctx, ConditionalUpdate{
+		URL:         url,
+		Header:      http.Header{"Accept": {"application/json"}, "Content-Type": {"application/json"}},
+		MaxAttempts: maxAttempts,
+		Mutate: func(current []byte) ([]byte, error) {
+			var v T
+			if err := json.Unmarshal(current, &v); err != nil {
+				return nil, err
+			}
+			if err := mutate(&v); err != nil {
+				return nil, err
+			}
+			written = v
+			return json.Marshal(v)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &written, nil
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpdateIfMatchRejectsWeakETag(t *testing.T) {
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writes++
+			return
+		}
+		w.Header().Set("ETag", `W/"1"`)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry()
+	_, err := h.UpdateIfMatch(context.Background(), ConditionalUpdate{
+		URL:    srv.URL,
+		Mutate: func(current []byte) ([]byte, error) { return current, nil },
+	})
+	if !errors.Is(err, ErrWeakETag) {
+		t.Fatalf("err = %v, want ErrWeakETag", err)
+	}
+	if writes != 0 {
+		t.Fatalf("%d writes sent with a weak etag", writes)
+	}
+}
+
+func TestUpdateIfMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// conflicts is the number of writes answered with 412 before one
+		// succeeds.
+		conflicts   int
+		maxAttempts int
+		wantErr     error
+		wantMutate  int
+	}{
+		{name: "first write", conflicts: 0, wantMutate: 1},
+		{name: "refetch after conflict", conflicts: 2, wantMutate: 3},
+		{name: "attempts used up", conflicts: 5, maxAttempts: 2, wantErr: ErrPreconditionFailed, wantMutate: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, writes := 1, 0
+			var ifMatch []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+					fmt.Fprintf(w, "v%d", version)
+					return
+				}
+				ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+				writes++
+				// Someone else wrote in between.
+				version++
+				if writes <= tt.conflicts {
+					w.WriteHeader(http.StatusPreconditionFailed)
+				}
+			}))
+			defer srv.Close()
+
+			var seen []string
+			h := NewHTTPRetry()
+			resp, err := h.UpdateIfMatch(context.Background(), ConditionalUpdate{
+				URL:         srv.URL,
+				MaxAttempts: tt.maxAttempts,
+				Mutate: func(current []byte) ([]byte, error) {
+					seen = append(seen, string(current))
+					return append(current, '!'), nil
+				},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			if len(seen) != tt.wantMutate {
+				t.Fatalf("Mutate called %d times, want %d", len(seen), tt.wantMutate)
+			}
+			for i, current := range seen {
+				if want := fmt.Sprintf("v%d", i+1); current != want {
+					t.Errorf("Mutate call %d saw %q, want the refetched %q", i, current, want)
+				}
+				if want := fmt.Sprintf(`"%d"`, i+1); ifMatch[i] != want {
+					t.Errorf("write %d sent If-Match %s, want %s", i, ifMatch[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// retryConfig is the configuration a single call to Do runs with. Do takes
+// it once so settings changed mid-request apply from the next request on.
+type retryConfig struct {
+	retries int
+	backoff int
+	client  *http.Client
+}
+
+func (h *HTTPRetry) snapshot() retryConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return retryConfig{retries: h.Retries, backoff: h.Backoff, client: h.HTTPClient}
+}
+
+type retriesKey struct{}
+
+// ContextWithRetries overrides the number of attempts for requests carrying
+// the returned context. Use 1 for requests that must not be sent twice.
+func ContextWithRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retriesKey{}, retries)
+}
+
+func (h *HTTPRetry) SetRetries(retries int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Retries = retries
+}
+
+func (h *HTTPRetry) SetBackoff(backoff int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Backoff = backoff
+}
+
+// SetTimeout swaps in a copy of the client with the new timeout, leaving the
+// client used by in-flight requests untouched.
+func (h *HTTPRetry) SetTimeout(timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client := *h.HTTPClient
+	client.Timeout = timeout
+	h.HTTPClient = &client
+	h.Timeout = timeout
+}
+
+// SetHTTPClient replaces the client used by subsequent requests. A copy of
+// client is used so that SSRF protection and the redirect checks of
+// WithSSRFProtection and WithRedirectPolicy stay in force; its transport is
+// cloned for that rather than modified. Other settings, such as the cookie
+// jar, are taken from client. With SSRF protection on, a client whose
+// transport is not an *http.Transport is rejected with ErrSSRFTransport and
+// the current client is kept.
+func (h *HTTPRetry) SetHTTPClient(client *http.Client) error {
+	c := *client
+	if h.ssrf != nil {
+		var t *http.Transport
+		switch rt := c.Transport.(type) {
+		case nil:
+			t = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			t = rt.Clone()
+		default:
+			return fmt.Errorf("%w, got %T", ErrSSRFTransport, rt)
+		}
+		h.ssrf.protect(t)
+		c.Transport = t
+	}
+	if len(h.redirectChecks) > 0 {
+		c.CheckRedirect = h.checkRedirect
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.HTTPClient = &c
+	h.Timeout = c.Timeout
+	return nil
+}
+
+func (h *HTTPRetry) Config() (retries, backoff int, timeout time.Duration) {
+	cfg := h.snapshot()
+	return cfg.retries, cfg.backoff, cfg.client.Timeout
+}
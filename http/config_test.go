@@ -0,0 +1,100 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type noSleep struct{}
+
+func (noSleep) Sleep(time.Duration) {}
+
+// TestConfigSettersRace is meant for go test -race: Do runs while every
+// setter is called concurrently.
+func TestConfigSettersRace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithBackoff(0))
+	h.sleep.Sleeper = noSleep{}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				url := srv.URL
+				if j%3 == 0 {
+					url += "/?fail=1"
+				}
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				if resp, err := h.Do(req); err == nil {
+					resp.Body.Close()
+				}
+			}
+		}(i)
+	}
+	setters := []func(i int){
+		func(i int) { h.SetRetries(1 + i%3) },
+		func(i int) { h.SetBackoff(i % 2) },
+		func(i int) { h.SetTimeout(time.Duration(1+i%5) * time.Second) },
+		func(i int) {
+			if err := h.SetHTTPClient(&http.Client{Timeout: 5 * time.Second}); err != nil {
+				t.Error(err)
+			}
+		},
+		func(int) { h.Config() },
+	}
+	for _, set := range setters {
+		wg.Add(1)
+		go func(set func(int)) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				set(i)
+			}
+		}(set)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestSetHTTPClientKeepsSSRFProtection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	h := NewHTTPRetry(WithRetries(1), WithSSRFProtection(SSRFPolicy{AllowedPorts: []int{port}}))
+	if err := h.SetHTTPClient(&http.Client{}); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:"+strconv.Itoa(port)+"/", nil)
+	if _, err := h.Do(req); !errors.Is(err, ErrBlockedDestination) {
+		t.Fatalf("Do after SetHTTPClient error = %v, want ErrBlockedDestination", err)
+	}
+
+	if err := h.SetHTTPClient(&http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}); !errors.Is(err, ErrSSRFTransport) {
+		t.Fatalf("SetHTTPClient with opaque transport error = %v, want ErrSSRFTransport", err)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
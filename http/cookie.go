@@ -0,0 +1,203 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+func WithCookieJar(jar http.CookieJar) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.HTTPClient.Jar = jar
+	}
+}
+
+// PersistentJar is a public-suffix-aware http.CookieJar whose cookies,
+// including session cookies, can be saved to and restored from a JSON file.
+type PersistentJar struct {
+	path string
+	jar  *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]persistedCookie
+}
+
+type persistedCookie struct {
+	URL      string        `json:"url"`
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Domain   string        `json:"domain,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Expires  time.Time     `json:"expires,omitempty"`
+	Secure   bool          `json:"secure,omitempty"`
+	HTTPOnly bool          `json:"httpOnly,omitempty"`
+	SameSite http.SameSite `json:"sameSite,omitempty"`
+}
+
+// NewPersistentJar creates a jar backed by path, loading any cookies
+// previously saved there. A missing file yields an empty jar.
+func NewPersistentJar(path string) (*PersistentJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	j := &PersistentJar{path: path, jar: jar, cookies: map[string]persistedCookie{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []persistedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, c := range saved {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{c.cookie()})
+	}
+	return j, nil
+}
+
+func (j *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		if !cookieDomainAllowed(u.Hostname(), c.Domain) {
+			continue
+		}
+		p := persistedCookie{
+			URL:      (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(),
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+			SameSite: c.SameSite,
+		}
+		if c.MaxAge > 0 {
+			p.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!p.Expires.IsZero() && p.Expires.Before(now)) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = p
+	}
+}
+
+func (j *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Save writes the jar's unexpired cookies to its file. The file is replaced
+// atomically and is only readable by the current user.
+func (j *PersistentJar) Save() error {
+	j.mu.Lock()
+	now := time.Now()
+	saved := make([]persistedCookie, 0, len(j.cookies))
+	for key, c := range j.cookies {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		saved = append(saved, c)
+	}
+	j.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+func (c persistedCookie) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Expires:  c.Expires,
+		Secure:   c.Secure,
+		HttpOnly: c.HTTPOnly,
+		SameSite: c.SameSite,
+	}
+}
+
+// cookieDomainAllowed mirrors the jar's checks so cookies it rejects, such as
+// ones scoped to a public suffix, are not persisted either.
+func cookieDomainAllowed(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	host = strings.ToLower(host)
+	if domain == "" || domain == host {
+		return true
+	}
+	if net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+domain) {
+		return false
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix != domain
+}
+
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if domain == "" {
+		domain = strings.ToLower(u.Hostname())
+	}
+	path := c.Path
+	if path == "" || path[0] != '/' {
+		path = cookieDefaultPath(u.Path)
+	}
+	return domain + ";" + path + ";" + c.Name
+}
+
+// cookieDefaultPath implements RFC 6265 section 5.1.4.
+func cookieDefaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
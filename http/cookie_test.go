@@ -0,0 +1,114 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func cookieNames(cookies []*http.Cookie) map[string]string {
+	names := map[string]string{}
+	for _, c := range cookies {
+		names[c.Name] = c.Value
+	}
+	return names
+}
+
+func TestPersistentJarRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	jar, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://www.example.com/app/login")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "s1"},
+		{Name: "pref", Value: "dark", Domain: "example.com", Path: "/", MaxAge: 3600},
+		{Name: "gone", Value: "x", MaxAge: -1},
+	})
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("cookie file mode = %o, want it readable by the owner only", perm)
+	}
+
+	reloaded, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cookieNames(reloaded.Cookies(u))
+	if got["session"] != "s1" || got["pref"] != "dark" || len(got) != 2 {
+		t.Errorf("cookies after reload = %v, want session and pref", got)
+	}
+	other, _ := url.Parse("https://api.example.com/")
+	if got := cookieNames(reloaded.Cookies(other)); got["pref"] != "dark" || len(got) != 1 {
+		t.Errorf("cookies for a sibling host after reload = %v, want only the domain cookie", got)
+	}
+}
+
+func TestPersistentJarRejectsPublicSuffix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	jar, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://foo.co.uk/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "wide", Value: "x", Domain: "co.uk"}})
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, _ := url.Parse("https://bar.co.uk/")
+	if got := reloaded.Cookies(bar); len(got) != 0 {
+		t.Errorf("public suffix cookie reached another site: %v", got)
+	}
+	if len(reloaded.cookies) != 0 {
+		t.Errorf("public suffix cookie persisted: %v", reloaded.cookies)
+	}
+}
+
+func TestPersistentJarDropsExpiredOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	saved := []persistedCookie{
+		{URL: "https://example.com/", Name: "live", Value: "1", Expires: time.Now().Add(time.Hour)},
+		{URL: "https://example.com/", Name: "stale", Value: "1", Expires: time.Now().Add(-time.Hour)},
+	}
+	data, _ := json.Marshal(saved)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	if got := cookieNames(jar.Cookies(u)); got["live"] != "1" || len(got) != 1 {
+		t.Errorf("cookies after load = %v, want only live", got)
+	}
+	if len(jar.cookies) != 1 {
+		t.Errorf("%d cookies kept for saving, want 1", len(jar.cookies))
+	}
+}
+
+func TestNewPersistentJarMissingFile(t *testing.T) {
+	jar, err := NewPersistentJar(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	if got := jar.Cookies(u); len(got) != 0 {
+		t.Errorf("cookies in a new jar = %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DashboardState is a snapshot of the client. Hosts is empty unless the
+// client was built WithStats. The client has no circuit breaker; Endpoints,
+// present WithEndpoints, carries the outlier ejection state that serves as
+// one.
+type DashboardState struct {
+	Time      time.Time       `json:"time"`
+	Config    ConfigState     `json:"config"`
+	Limiter   *LimiterState   `json:"limiter,omitempty"`
+	Endpoints []EndpointState `json:"endpoints,omitempty"`
+	Hosts     []HostStats     `json:"hosts"`
+}
+
+type ConfigState struct {
+	Retries int `json:"retries"`
+	Backoff int `json:"backoff"`
+	// Timeout is in nanoseconds in JSON.
+	Timeout time.Duration `json:"timeout"`
+}
+
+type LimiterState struct {
+	// Tokens is omitted when no rate limit is configured.
+	Tokens      *float64 `json:"tokens,omitempty"`
+	InFlight    int      `json:"inFlight"`
+	MaxInFlight int      `json:"maxInFlight,omitempty"`
+	Queued      int      `json:"queued"`
+}
+
+func (h *HTTPRetry) DashboardState() DashboardState {
+	retries, backoff, timeout := h.Config()
+	state := DashboardState{
+		Time:      time.Now(),
+		Config:    ConfigState{Retries: retries, Backoff: backoff, Timeout: timeout},
+		Endpoints: h.Endpoints(),
+		Hosts:     h.Stats(),
+	}
+	if g := h.gate; g != nil {
+		g.mu.Lock()
+		g.refillLocked()
+		l := &LimiterState{InFlight: g.inFlight, MaxInFlight: g.maxInFlight, Queued: g.queuedLocked()}
+		if g.rate > 0 {
+			tokens := g.tokens
+			l.Tokens = &tokens
+		}
+		g.mu.Unlock()
+		state.Limiter = l
+	}
+	return state
+}
+
+// DashboardHandler serves the client's live state as JSON, or as an HTML page
+// when requested with ?format=html or an Accept header preferring text/html.
+func (h *HTTPRetry) DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := h.DashboardState()
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Query().Get("format") == "html" ||
+			(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := dashboardTemplate.Execute(w, state); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"pct":   func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+	"ms":    func(d time.Duration) string { return d.Round(time.Microsecond).String() },
+	"deref": func(f *float64) float64 { return *f },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>HTTPRetry</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>HTTPRetry</h1>
+<p>{{.Time.Format "2006-01-02 15:04:05 MST"}} &middot; retries {{.Config.Retries}} &middot; backoff {{.Config.Backoff}}s &middot; timeout {{.Config.Timeout}}</p>
+{{with .Limiter}}
+<h2>Limiter</h2>
+<table>
+<tr><th>Tokens</th><th>In flight</th><th>Max in flight</th><th>Queued</th></tr>
+<tr><td>{{if .Tokens}}{{printf "%.2f" (deref .Tokens)}}{{else}}-{{end}}</td><td>{{.InFlight}}</td><td>{{if .MaxInFlight}}{{.MaxInFlight}}{{else}}-{{end}}</td><td>{{.Queued}}</td></tr>
+</table>
+{{end}}
+{{with .Endpoints}}
+<h2>Endpoints</h2>
+<table>
+<tr><th>URL</th><th>State</th><th>Ejections</th><th>Consecutive 5xx</th><th>Attempts</th><th>Failures</th></tr>
+{{range .}}<tr><td>{{.URL}}</td><td>{{if .Ejected}}ejected until {{.EjectedUntil.Format "15:04:05"}}{{else}}active{{end}}</td><td>{{.Ejections}}</td><td>{{.Consecutive5xx}}</td><td>{{.Attempts}}</td><td>{{.Failures}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Hosts</h2>
+<table>
+<tr><th>Host</th><th>Requests</th><th>Success</th><th>Retry rate</th><th>Attempts</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{range .Hosts}}<tr><td>{{.Host}}</td><td>{{.Requests}}</td><td>{{pct .SuccessRate}}</td><td>{{pct .RetryRate}}</td><td>{{.Attempts}}</td><td>{{ms .Latency.P50}}</td><td>{{ms .Latency.P95}}</td><td>{{ms .Latency.P99}}</td></tr>
+{{end}}</table>
+<h2>Recent failures</h2>
+<table>
+<tr><th>Time</th><th>URL</th><th>Status</th><th>Error</th></tr>
+{{range .Hosts}}{{range .RecentFailures}}<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.URL}}</td><td>{{if .StatusCode}}{{.StatusCode}}{{end}}</td><td>{{.Error}}</td></tr>
+{{end}}{{end}}</table>
+</body>
+</html>
+`))
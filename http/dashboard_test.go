@@ -0,0 +1,69 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithStats(0), WithRetries(2), WithBackoff(0), WithMaxConcurrency(4))
+	h.sleep.Sleeper = noSleep{}
+	for _, path := range []string{"/", "/fail"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if resp, err := h.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	state := h.DashboardState()
+	if state.Config.Retries != 2 || state.Limiter == nil || state.Limiter.MaxInFlight != 4 || state.Limiter.Tokens != nil {
+		t.Fatalf("state = %+v", state)
+	}
+	if len(state.Hosts) != 1 {
+		t.Fatalf("hosts = %+v", state.Hosts)
+	}
+	host := state.Hosts[0]
+	if host.Requests != 2 || host.Successes != 1 || host.Attempts != 3 || host.SuccessRate != 0.5 || len(host.RecentFailures) != 2 {
+		t.Fatalf("host = %+v", host)
+	}
+
+	handler := h.DashboardHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var decoded DashboardState
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Hosts) != 1 || decoded.Hosts[0].Requests != 2 {
+		t.Fatalf("decoded = %+v", decoded)
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/?format=html", nil),
+		func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", "text/html,application/xhtml+xml")
+			return r
+		}(),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "<h2>Hosts</h2>") || !strings.Contains(body, "502") {
+			t.Fatalf("html dashboard = %s", body)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrDeadlineBudgetExhausted = errors.New("remaining deadline budget below minimum")
+
+type DeadlineHeaderFormat int
+
+const (
+	// DeadlineMilliseconds sends whole milliseconds, e.g. "1500".
+	DeadlineMilliseconds DeadlineHeaderFormat = iota
+	// DeadlineSeconds sends fractional seconds, e.g. "1.5".
+	DeadlineSeconds
+	// DeadlineGRPC sends a grpc-timeout value, e.g. "1500m".
+	DeadlineGRPC
+)
+
+type DeadlinePropagation struct {
+	// Header defaults to X-Request-Timeout.
+	Header string
+	Format DeadlineHeaderFormat
+	// MinRemaining skips attempts, and the backoff before them, when less
+	// than this much time would remain.
+	MinRemaining time.Duration
+	// Margin is subtracted from the advertised budget to allow for the
+	// response's trip back.
+	Margin time.Duration
+}
+
+// WithDeadlinePropagation sends the time remaining until the request's
+// context deadline, or the client timeout if sooner, with every attempt.
+func WithDeadlinePropagation(p DeadlinePropagation) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if p.Header == "" {
+			p.Header = "X-Request-Timeout"
+		}
+		h.deadline = &p
+	}
+}
+
+// remainingBudget reports the budget left for an attempt starting after
+// wait, and whether any budget applies at all.
+func remainingBudget(ctx context.Context, client *http.Client, wait time.Duration) (time.Duration, bool) {
+	remaining, ok := time.Duration(0), false
+	if deadline, has := ctx.Deadline(); has {
+		remaining, ok = time.Until(deadline)-wait, true
+	}
+	if t := client.Timeout; t > 0 && (!ok || t < remaining) {
+		remaining, ok = t, true
+	}
+	return remaining, ok
+}
+
+func (h *HTTPRetry) checkBudget(ctx context.Context, client *http.Client, wait time.Duration) error {
+	if h.deadline == nil {
+		return nil
+	}
+	remaining, ok := remainingBudget(ctx, client, wait)
+	if ok && remaining < h.deadline.MinRemaining {
+		return fmt.Errorf("%w: %s left", ErrDeadlineBudgetExhausted, remaining)
+	}
+	return nil
+}
+
+func (h *HTTPRetry) propagateDeadline(req *http.Request, client *http.Client) {
+	if h.deadline == nil {
+		return
+	}
+	remaining, ok := remainingBudget(req.Context(), client, 0)
+	if !ok {
+		return
+	}
+	remaining -= h.deadline.Margin
+	if remaining < 0 {
+		remaining = 0
+	}
+	req.Header.Set(h.deadline.Header, formatDeadline(remaining, h.deadline.Format))
+}
+
+func formatDeadline(d time.Duration, format DeadlineHeaderFormat) string {
+	switch format {
+	case DeadlineSeconds:
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	case DeadlineGRPC:
+		return grpcTimeout(d)
+	default:
+		return strconv.FormatInt(d.Milliseconds(), 10)
+	}
+}
+
+// grpcTimeout encodes d in the largest precision that fits the eight digits
+// the gRPC wire format allows.
+func grpcTimeout(d time.Duration) string {
+	const maxValue = 99999999
+	units := []struct {
+		unit string
+		size time.Duration
+	}{
+		{"n", time.Nanosecond},
+		{"u", time.Microsecond},
+		{"m", time.Millisecond},
+		{"S", time.Second},
+		{"M", time.Minute},
+		{"H", time.Hour},
+	}
+	for _, u := range units {
+		if v := d / u.size; v <= maxValue {
+			return strconv.FormatInt(int64(v), 10) + u.unit
+		}
+	}
+	return strconv.Itoa(maxValue) + "H"
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFormatDeadline(t *testing.T) {
+	tests := []struct {
+		d      time.Duration
+		format DeadlineHeaderFormat
+		want   string
+	}{
+		{d: 1500 * time.Millisecond, format: DeadlineMilliseconds, want: "1500"},
+		{d: 1500 * time.Millisecond, format: DeadlineSeconds, want: "1.5"},
+		{d: 0, format: DeadlineSeconds, want: "0"},
+		{d: 1500 * time.Millisecond, format: DeadlineGRPC, want: "1500000u"},
+		{d: 99999999 * time.Nanosecond, format: DeadlineGRPC, want: "99999999n"},
+		{d: 30 * time.Second, format: DeadlineGRPC, want: "30000000u"},
+		{d: 48 * time.Hour, format: DeadlineGRPC, want: "172800S"},
+		{d: 1 << 62, format: DeadlineGRPC, want: "76861433M"},
+	}
+	for _, tt := range tests {
+		if got := formatDeadline(tt.d, tt.format); got != tt.want {
+			t.Errorf("formatDeadline(%s, %d) = %q, want %q", tt.d, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get("Grpc-Timeout"))
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithTimeout(time.Minute), WithDeadlinePropagation(DeadlinePropagation{
+		Header: "Grpc-Timeout",
+		Format: DeadlineMilliseconds,
+		Margin: 100 * time.Millisecond,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ms, err := strconv.Atoi(got.Load().(string))
+	if err != nil {
+		t.Fatalf("header = %q: %v", got.Load(), err)
+	}
+	// The context deadline is sooner than the client timeout, less the margin.
+	if ms > 1900 || ms < 1500 {
+		t.Errorf("advertised budget = %dms, want just under 1900ms", ms)
+	}
+}
+
+func TestDeadlinePropagationMinRemaining(t *testing.T) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithDeadlinePropagation(DeadlinePropagation{MinRemaining: time.Second}))
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := h.Do(req); !errors.Is(err, ErrDeadlineBudgetExhausted) {
+		t.Fatalf("Do error = %v, want ErrDeadlineBudgetExhausted", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("server hit %d times with too little budget left", n)
+	}
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// Sixteen linear sub-buckets per power of two bound the relative error of
+	// a reported percentile to about 6%.
+	histogramSubBuckets = 16
+	histogramExponents  = 40
+	histogramBuckets    = histogramExponents * histogramSubBuckets
+
+	defaultLatencyHalfLife = 5 * time.Minute
+)
+
+// latencyHistogram is a log-linear histogram of microsecond latencies, in the
+// style of HDR histograms, whose counts halve every halfLife so percentiles
+// follow recent behaviour.
+type latencyHistogram struct {
+	counts    [histogramBuckets]float64
+	total     float64
+	halfLife  time.Duration
+	lastDecay time.Time
+}
+
+func newLatencyHistogram(halfLife time.Duration, now time.Time) *latencyHistogram {
+	return &latencyHistogram{halfLife: halfLife, lastDecay: now}
+}
+
+func (l *latencyHistogram) record(d time.Duration, now time.Time) {
+	l.decay(now)
+	l.counts[histogramIndex(d)]++
+	l.total++
+}
+
+// decay scales counts down for the time passed since the last decay. It only
+// runs once a tenth of the half-life has passed to keep recording cheap.
+func (l *latencyHistogram) decay(now time.Time) {
+	if l.halfLife <= 0 {
+		return
+	}
+	elapsed := now.Sub(l.lastDecay)
+	if elapsed < l.halfLife/10 {
+		return
+	}
+	factor := math.Exp2(-float64(elapsed) / float64(l.halfLife))
+	l.total = 0
+	for i := range l.counts {
+		l.counts[i] *= factor
+		l.total += l.counts[i]
+	}
+	l.lastDecay = now
+}
+
+func (l *latencyHistogram) quantile(q float64) time.Duration {
+	if l.total <= 0 {
+		return 0
+	}
+	target := q * l.total
+	var seen float64
+	for i, c := range l.counts {
+		seen += c
+		if c > 0 && seen >= target {
+			return histogramValue(i)
+		}
+	}
+	return histogramValue(histogramBuckets - 1)
+}
+
+func (l *latencyHistogram) percentiles(now time.Time) LatencyPercentiles {
+	l.decay(now)
+	return LatencyPercentiles{
+		P50:   l.quantile(0.5),
+		P95:   l.quantile(0.95),
+		P99:   l.quantile(0.99),
+		Count: l.total,
+	}
+}
+
+func histogramIndex(d time.Duration) int {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d / time.Microsecond)
+	}
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// Shift v so it lands in [16, 32), i.e. one linear sub-bucket of its power of two.
+	e := bits.Len64(v) - 5
+	i := (e+1)*histogramSubBuckets + int(v>>e) - histogramSubBuckets
+	if i >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return i
+}
+
+// histogramValue returns the midpoint of bucket i.
+func histogramValue(i int) time.Duration {
+	if i < histogramSubBuckets {
+		return time.Duration(i) * time.Microsecond
+	}
+	e := i/histogramSubBuckets - 1
+	lower := uint64(i%histogramSubBuckets+histogramSubBuckets) << e
+	return time.Duration(lower+(uint64(1)<<e)/2) * time.Microsecond
+}
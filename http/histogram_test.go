@@ -0,0 +1,111 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramRoundTrip(t *testing.T) {
+	prev := -1
+	for us := int64(0); us < int64(1)<<36; us = us*9/8 + 1 {
+		d := time.Duration(us) * time.Microsecond
+		i := histogramIndex(d)
+		if i < prev {
+			t.Fatalf("histogramIndex(%s) = %d, below %d for a smaller value", d, i, prev)
+		}
+		prev = i
+		got := histogramValue(i)
+		if us < histogramSubBuckets {
+			if got != d {
+				t.Fatalf("histogramValue(histogramIndex(%s)) = %s, want exact", d, got)
+			}
+			continue
+		}
+		// The midpoint of a bucket is within half a sub-bucket, 1/32, of
+		// any value in it.
+		if rel := math.Abs(float64(got-d)) / float64(d); rel > 1.0/32 {
+			t.Fatalf("histogramValue(histogramIndex(%s)) = %s, relative error %.3f", d, got, rel)
+		}
+	}
+	if i := histogramIndex(-time.Second); i != 0 {
+		t.Errorf("histogramIndex of a negative duration = %d, want 0", i)
+	}
+	if i := histogramIndex(time.Duration(math.MaxInt64)); i != histogramBuckets-1 {
+		t.Errorf("histogramIndex of the largest duration = %d, want the last bucket", i)
+	}
+}
+
+func TestHistogramQuantileAccuracy(t *testing.T) {
+	const n = 10000
+	tests := []struct {
+		name string
+		// inverse is the distribution's quantile function.
+		inverse func(q float64) time.Duration
+	}{
+		{name: "uniform 0-100ms", inverse: func(q float64) time.Duration {
+			return time.Duration(q * float64(100*time.Millisecond))
+		}},
+		{name: "exponential mean 20ms", inverse: func(q float64) time.Duration {
+			return time.Duration(-math.Log(1-q) * float64(20*time.Millisecond))
+		}},
+		{name: "constant 250ms", inverse: func(float64) time.Duration { return 250 * time.Millisecond }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			l := newLatencyHistogram(0, now)
+			for i := 1; i <= n; i++ {
+				l.record(tt.inverse(float64(i)/(n+1)), now)
+			}
+			for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+				want := tt.inverse(q)
+				got := l.quantile(q)
+				if rel := math.Abs(float64(got-want)) / float64(want); rel > 0.07 {
+					t.Errorf("p%.0f = %s, want %s (relative error %.3f)", q*100, got, want, rel)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramDecay(t *testing.T) {
+	start := time.Now()
+	halfLife := time.Minute
+	l := newLatencyHistogram(halfLife, start)
+	for i := 0; i < 100; i++ {
+		l.record(100*time.Millisecond, start)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{elapsed: halfLife / 20, want: 100}, // below the decay step
+		{elapsed: halfLife, want: 50},
+		{elapsed: 3 * halfLife, want: 12.5},
+	}
+	for _, tt := range tests {
+		l.decay(start.Add(tt.elapsed))
+		if math.Abs(l.total-tt.want) > 1e-9 {
+			t.Errorf("total after %s = %f, want %f", tt.elapsed, l.total, tt.want)
+		}
+	}
+
+	// Ten half-lives on, the old samples weigh about 0.1 against ten fresh
+	// ones and no longer reach even the 99th percentile.
+	now := start.Add(10 * halfLife)
+	for i := 0; i < 10; i++ {
+		l.record(time.Millisecond, now)
+	}
+	if p := l.percentiles(now); p.P99 > 2*time.Millisecond {
+		t.Errorf("percentiles = %+v, want the old samples faded out", p)
+	}
+
+	kept := newLatencyHistogram(0, start)
+	kept.record(time.Millisecond, start)
+	kept.decay(start.Add(time.Hour))
+	if kept.total != 1 {
+		t.Errorf("total with no half-life = %f, want 1", kept.total)
+	}
+}
@@ -1,14 +1,14 @@
 package common
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"net/http"
-	"net/http/httputil"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 var (
@@ -17,6 +17,34 @@ var (
 	defaultTimeout = time.Second * 30
 )
 
+var ErrRetriesExhausted = errors.New("http request failed")
+
+// RetriesExhaustedError is returned by Do once every attempt has failed. It
+// carries the outcome of the last attempt: its status when the server
+// answered with a 5xx, or its error otherwise.
+type RetriesExhaustedError struct {
+	Attempts   int
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	last := e.Status
+	if e.Err != nil {
+		last = e.Err.Error()
+	}
+	return fmt.Sprintf("http request failed after %d attempts: %s", e.Attempts, last)
+}
+
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
 type TimeSleep struct{}
 
 func (*TimeSleep) Sleep(d time.Duration) { time.Sleep(d) }
@@ -37,12 +65,35 @@ func (s *sleep) Sleep(d time.Duration) {
 	}
 }
 
+// HTTPRetry's exported fields may be set before the client is shared. Once
+// requests are in flight, change them through the Set methods instead.
 type HTTPRetry struct {
 	Retries    int
 	Backoff    int
 	Timeout    time.Duration
 	HTTPClient *http.Client
 	sleep      sleep
+
+	mu    sync.RWMutex
+	stats *statsRegistry
+
+	maxResponseSize int64
+	ssrf            *SSRFPolicy
+	redirectChecks  []func(req *http.Request, via []*http.Request) error
+	maxRedirects    int
+	recordRedirects bool
+	gate            *requestGate
+	defaultPriority Priority
+	deadline        *DeadlinePropagation
+	adaptive        *AdaptiveTimeout
+	endpoints       *endpointPool
+	backoffStrategy BackoffStrategy
+	log             *zerolog.Logger
+	retryLog        *retryLogSampler
+	audit           *auditor
+	bodyLog         *BodyLogging
+	tokens          TokenSource
+	offline         *offlineQueue
 }
 
 func NewHTTPRetry(options ...func(*HTTPRetry)) *HTTPRetry {
@@ -52,6 +103,11 @@ func NewHTTPRetry(options ...func(*HTTPRetry)) *HTTPRetry {
 		},
 		Retries: defaultRetries,
 		Backoff: defaultBackoff,
+		Timeout: defaultTimeout,
+
+		maxRedirects:    defaultMaxRedirects,
+		defaultPriority: PriorityNormal,
+		backoffStrategy: LinearBackoff,
 	}
 	for _, o := range options {
 		o(instance)
@@ -73,45 +129,230 @@ func WithBackoff(backoff int) func(httpRetry *HTTPRetry) {
 
 func WithTimeout(timeout time.Duration) func(httpRetry *HTTPRetry) {
 	return func(h *HTTPRetry) {
-		h.HTTPClient = &http.Client{
-			Timeout: timeout,
+		h.Timeout = timeout
+		h.HTTPClient.Timeout = timeout
+	}
+}
+
+// transport returns the *http.Transport underneath the client, installing a
+// clone of http.DefaultTransport when none is set. It returns nil when the
+// client uses a RoundTripper it cannot see through.
+func (h *HTTPRetry) transport() *http.Transport {
+	rt := &h.HTTPClient.Transport
+	for {
+		switch t := (*rt).(type) {
+		case nil:
+			clone := http.DefaultTransport.(*http.Transport).Clone()
+			*rt = clone
+			return clone
+		case *http.Transport:
+			return t
+		case *ChaosTransport:
+			rt = &t.Base
+		default:
+			return nil
 		}
 	}
 }
 
+func (h *HTTPRetry) addRedirectCheck(check func(req *http.Request, via []*http.Request) error) {
+	h.redirectChecks = append(h.redirectChecks, check)
+	h.HTTPClient.CheckRedirect = h.checkRedirect
+}
+
+func (h *HTTPRetry) checkRedirect(req *http.Request, via []*http.Request) error {
+	for _, check := range h.redirectChecks {
+		if err := check(req, via); err != nil {
+			return err
+		}
+	}
+	if h.maxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= h.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", h.maxRedirects)
+	}
+	if chain, ok := req.Context().Value(redirectChainKey{}).(*redirectChain); ok {
+		chain.add(req.URL)
+	}
+	return nil
+}
+
+// Do sends req, retrying as configured. A request body without GetBody is
+// buffered and req.Body and req.GetBody are replaced, so req can be sent
+// again. Do only reads a request that has GetBody, as NewRequest sets for
+// in-memory bodies, so such a request may be sent from several goroutines at
+// once; one without GetBody must not be shared until its first Do returns.
 func (h *HTTPRetry) Do(req *http.Request) (*http.Response, error) {
-	var bod []byte
-	if req.Body != nil {
-		var err error
-		bod, err = io.ReadAll(req.Body)
-		if err != nil {
-			log.Warn().Fields(map[string]interface{}{"req": req}).Msg("Unable to read body from request")
-		} else {
-			req.Body.Close()
-			req.Body = io.NopCloser(bytes.NewReader(bod))
+	if err := replayable(req); err != nil {
+		h.logger(req.Context()).Warn().Fields(map[string]interface{}{"err": err, "URL": RedactURL(req.URL)}).Msg("Unable to read body from request")
+		return nil, err
+	}
+	if h.offline != nil {
+		return h.offline.do(req, h.send)
+	}
+	return h.send(req)
+}
+
+// Close stops background work. Requests waiting in the offline queue fail
+// with ErrOfflineQueueClosed, suppressed retry warnings are summarized, then
+// queued audit records are flushed and the audit sink is closed.
+func (h *HTTPRetry) Close() error {
+	if h.offline != nil {
+		h.offline.close()
+	}
+	if h.retryLog != nil {
+		h.retryLog.close()
+	}
+	if h.audit != nil {
+		return h.audit.close()
+	}
+	return nil
+}
+
+func (h *HTTPRetry) send(req *http.Request) (*http.Response, error) {
+	do := h.do
+	if h.audit != nil {
+		do = h.audited
+	}
+	resp, attempts, err := do(req)
+	h.stats.request(req.URL.Host, attempts, err)
+	return resp, err
+}
+
+func (h *HTTPRetry) do(req *http.Request) (*http.Response, int, error) {
+	logger := h.logger(req.Context())
+	getBody := req.GetBody
+	if req.Body == nil || req.Body == http.NoBody {
+		getBody = nil
+	}
+
+	h.logRequestBody(logger, req, getBody)
+
+	if h.ssrf != nil {
+		if err := h.ssrf.checkURL(req.URL); err != nil {
+			return nil, 0, err
 		}
 	}
 
-	for currentTries := 0; currentTries < h.Retries; currentTries++ {
-		log.Trace().Fields(map[string]interface{}{"Current tries": currentTries, "URL": req.URL.String()}).Msg("Http request")
+	cfg := h.snapshot()
+	ctx := req.Context()
+	if retries, ok := ctx.Value(retriesKey{}).(int); ok && retries > 0 {
+		cfg.retries = retries
+	}
+	var chain *redirectChain
+	if h.recordRedirects {
+		ctx, chain = contextWithRedirectChain(ctx)
+	}
+
+	attempts := 0
+	exhausted := &RetriesExhaustedError{}
+	for currentTries := 0; currentTries < cfg.retries; currentTries++ {
+		if err := h.checkBudget(ctx, cfg.client, 0); err != nil {
+			return nil, attempts, err
+		}
+		logger.Trace().Fields(map[string]interface{}{"Current tries": currentTries, "URL": req.URL.String()}).Msg("Http request")
 
-		resp, err := h.HTTPClient.Do(req)
+		target := req.URL
+		var ep *endpoint
+		if h.endpoints != nil {
+			if ep = h.endpoints.pick(logger); ep != nil {
+				target = endpointURL(ep, req.URL)
+			}
+		}
+		if h.gate != nil {
+			if err := h.gate.acquire(ctx, h.priority(ctx)); err != nil {
+				return nil, attempts, err
+			}
+		}
+		// A successful attempt holds its concurrency slot until the caller
+		// closes the body, so that streamed bodies count as in flight.
+		release := func() {
+			if h.gate != nil {
+				h.gate.release()
+			}
+		}
+		// The adaptive timeout starts once the gate has admitted the attempt.
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		var headers *headerTimeout
+		if timeout := h.attemptTimeout(req.URL.Host, cfg.client); timeout > 0 {
+			attemptCtx, cancel = context.WithCancel(ctx)
+			headers = startHeaderTimeout(timeout, cancel)
+		}
+		if chain != nil {
+			chain.reset(target)
+		}
+		attempt, err := cloneWithBody(attemptCtx, req, getBody)
+		if err == nil {
+			if ep != nil {
+				attempt.URL, attempt.Host = target, ""
+			}
+			err = h.authorize(attempt)
+		}
+		if err != nil {
+			headers.stop(nil)
+			cancel()
+			release()
+			return nil, attempts, err
+		}
+		h.propagateDeadline(attempt, cfg.client)
+		countSent(attempt)
+		attempts++
+		start := time.Now()
+		resp, err := cfg.client.Do(attempt)
+		err = headers.stop(err)
+		if err != nil || resp.StatusCode >= 500 {
+			release()
+		}
+		h.stats.attempt(req.URL.Host, target, time.Since(start), resp, err)
+		if ep != nil {
+			h.endpoints.report(ep, err != nil || resp.StatusCode >= 500, logger)
+		}
+		if errors.Is(err, ErrBlockedDestination) || errors.Is(err, ErrRedirectBlocked) || errors.Is(err, ErrSSRFTransport) {
+			cancel()
+			return nil, attempts, err
+		}
 		if err != nil || resp.StatusCode >= 500 {
-			log.Warn().Fields(map[string]interface{}{"err": err, "retryCount": currentTries, "responseStatusCode": resp.StatusCode, "responseStatus": resp.Status}).Msg("Http Request Error")
-			if len(bod) > 0 {
-				req.Body = io.NopCloser(bytes.NewReader(bod))
+			cancel()
+			exhausted.Err, exhausted.StatusCode, exhausted.Status = err, 0, ""
+			fields := map[string]interface{}{"err": err, "retryCount": currentTries}
+			if resp != nil {
+				exhausted.StatusCode, exhausted.Status = resp.StatusCode, resp.Status
+				fields["responseStatusCode"] = resp.StatusCode
+				fields["responseStatus"] = resp.Status
+				h.logFailedResponseBody(logger, resp)
+				resp.Body.Close()
+			}
+			if h.retryLog == nil || h.retryLog.allow(target.Host) {
+				logger.Warn().Fields(fields).Msg("Http Request Error")
+			}
+			// There is nothing to wait for after the last attempt.
+			if currentTries+1 < cfg.retries {
+				backoff := h.backoffStrategy(currentTries, cfg.backoff)
+				if err := h.checkBudget(ctx, cfg.client, backoff); err != nil {
+					return nil, attempts, err
+				}
+				h.sleep.Sleep(backoff)
 			}
-			h.sleep.Sleep(time.Duration(currentTries*h.Backoff) * time.Second)
 			continue
 		}
 
-		return resp, nil
-	}
-	dat, err := httputil.DumpRequest(req, true)
-	if err != nil {
-		log.Info().Fields(map[string]interface{}{"req": string(dat)}).Msg("Max retry limit for request")
-	} else {
-		log.Info().Fields(map[string]interface{}{"req": req}).Msg("Max retry limit for request. Also failed to print the request")
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: func() {
+			cancel()
+			release()
+		}}
+		if err := limitResponse(resp, h.responseSizeLimit(ctx)); err != nil {
+			return nil, attempts, err
+		}
+		h.logResponseBody(logger, resp)
+		return resp, attempts, nil
 	}
-	return nil, errors.New("http request failed")
+	// The body was already logged, redacted, by logRequestBody.
+	logger.Info().Fields(map[string]interface{}{
+		"method":  req.Method,
+		"URL":     RedactURL(req.URL),
+		"headers": RedactHeaders(req.Header),
+	}).Msg("Max retry limit for request")
+	exhausted.Attempts = attempts
+	return nil, attempts, exhausted
 }
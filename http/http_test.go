@@ -0,0 +1,53 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoRetriesExhausted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithRetries(3), WithBackoff(0))
+	h.sleep.Sleeper = noSleep{}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	_, err := h.Do(req)
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("err = %v, want ErrRetriesExhausted", err)
+	}
+	var exhausted *RetriesExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Fatalf("err = %T, want *RetriesExhaustedError", err)
+	}
+	if exhausted.Attempts != 3 || exhausted.StatusCode != http.StatusServiceUnavailable || exhausted.Err != nil {
+		t.Fatalf("exhausted = %+v", exhausted)
+	}
+}
+
+type recordingSleep struct{ waits []time.Duration }
+
+func (r *recordingSleep) Sleep(d time.Duration) { r.waits = append(r.waits, d) }
+
+func TestDoSkipsBackoffAfterLastAttempt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	sleeps := &recordingSleep{}
+	h := NewHTTPRetry(WithRetries(3), WithBackoff(1))
+	h.sleep.Sleeper = sleeps
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := h.Do(req); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("err = %v, want ErrRetriesExhausted", err)
+	}
+	if want := []time.Duration{0, time.Second}; len(sleeps.waits) != len(want) || sleeps.waits[0] != want[0] || sleeps.waits[1] != want[1] {
+		t.Errorf("waits = %v, want %v", sleeps.waits, want)
+	}
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var ErrResponseTooLarge = errors.New("http response too large")
+
+type ResponseTooLargeError struct {
+	Limit int64
+	// Decompressed is set when the limit was exceeded by the decoded body.
+	Decompressed bool
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	if e.Decompressed {
+		return fmt.Sprintf("http response exceeds %d bytes after decompression", e.Limit)
+	}
+	return fmt.Sprintf("http response exceeds %d bytes", e.Limit)
+}
+
+func (e *ResponseTooLargeError) Is(target error) bool {
+	return target == ErrResponseTooLarge
+}
+
+// WithMaxResponseSize limits the number of body bytes a caller can read from
+// responses returned by Do. Zero disables the limit.
+func WithMaxResponseSize(n int64) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.maxResponseSize = n
+	}
+}
+
+type maxResponseSizeKey struct{}
+
+// ContextWithMaxResponseSize overrides the client's response size limit for
+// requests carrying the returned context. Zero disables the limit.
+func ContextWithMaxResponseSize(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxResponseSizeKey{}, n)
+}
+
+func (h *HTTPRetry) responseSizeLimit(ctx context.Context) int64 {
+	if n, ok := ctx.Value(maxResponseSizeKey{}).(int64); ok {
+		return n
+	}
+	return h.maxResponseSize
+}
+
+// limitResponse enforces limit on resp's body. Bodies the transport left
+// compressed are decoded here so the decompressed size is bounded as well.
+func limitResponse(resp *http.Response, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return &ResponseTooLargeError{Limit: limit}
+	}
+
+	raw := &limitedReader{r: resp.Body, remaining: limit, err: &ResponseTooLargeError{Limit: limit}}
+	var decoded io.Reader
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		if resp.Uncompressed {
+			break
+		}
+		decoded = &lazyGzipReader{r: raw}
+	case "deflate":
+		decoded = &lazyDeflateReader{r: raw}
+	}
+
+	if decoded == nil {
+		resp.Body = &limitedBody{Reader: raw, Closer: resp.Body}
+		return nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	resp.Body = &limitedBody{
+		Reader: &limitedReader{r: decoded, remaining: limit, err: &ResponseTooLargeError{Limit: limit, Decompressed: true}},
+		Closer: resp.Body,
+	}
+	return nil
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	// Read one byte past the limit so an exact-size body is not reported as too large.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, l.err
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// lazyGzipReader defers reading the gzip header to the first Read, so that
+// empty bodies such as those of HEAD requests and 204s decode to nothing
+// instead of failing.
+type lazyGzipReader struct {
+	r  io.Reader
+	zr *gzip.Reader
+}
+
+func (g *lazyGzipReader) Read(p []byte) (int, error) {
+	if g.zr == nil {
+		zr, err := gzip.NewReader(g.r)
+		if err != nil {
+			return 0, err
+		}
+		g.zr = zr
+	}
+	return g.zr.Read(p)
+}
+
+// lazyDeflateReader decodes deflate bodies, which RFC 9110 defines as zlib
+// streams but some servers send as raw deflate. The header is inspected on
+// the first Read, so empty bodies decode to nothing.
+type lazyDeflateReader struct {
+	r  io.Reader
+	dr io.Reader
+}
+
+func (d *lazyDeflateReader) Read(p []byte) (int, error) {
+	if d.dr == nil {
+		br := bufio.NewReader(d.r)
+		header, err := br.Peek(2)
+		switch {
+		case len(header) == 0:
+			return 0, err
+		case len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0:
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return 0, err
+			}
+			d.dr = zr
+		default:
+			d.dr = flate.NewReader(br)
+		}
+	}
+	return d.dr.Read(p)
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitResponseGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/empty" {
+			return
+		}
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, r.URL.Query().Get("body"))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "head", method: http.MethodHead, path: "/?body=hello", want: ""},
+		{name: "empty body", method: http.MethodGet, path: "/empty", want: ""},
+		{name: "within limit", method: http.MethodGet, path: "/?body=hello", want: "hello"},
+		{name: "decompressed over limit", method: http.MethodGet, path: "/?body=" + strings.Repeat("a", 200), wantErr: ErrResponseTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPRetry(WithMaxResponseSize(100))
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := h.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("read error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitResponseDeflate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		var zw io.WriteCloser
+		switch r.URL.Path {
+		case "/empty":
+			return
+		case "/raw":
+			zw, _ = flate.NewWriter(w, flate.DefaultCompression)
+		default:
+			zw = zlib.NewWriter(w)
+		}
+		io.WriteString(zw, r.URL.Query().Get("body"))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "zlib", path: "/?body=hello", want: "hello"},
+		{name: "raw deflate", path: "/raw?body=hello", want: "hello"},
+		{name: "empty body", path: "/empty", want: ""},
+		{name: "decompressed over limit", path: "/?body=" + strings.Repeat("a", 200), wantErr: ErrResponseTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPRetry(WithMaxResponseSize(100))
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			req.Header.Set("Accept-Encoding", "deflate")
+			resp, err := h.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("read error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// WithLogger sets the logger used for requests whose context carries no
+// logger of its own.
+func WithLogger(logger zerolog.Logger) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.log = &logger
+	}
+}
+
+// logger returns the logger attached to ctx, then the client's logger, then
+// zerolog's default context logger, then the global logger.
+func (h *HTTPRetry) logger(ctx context.Context) *zerolog.Logger {
+	l := zerolog.Ctx(ctx)
+	if l != zerolog.DefaultContextLogger && l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	if h.log != nil {
+		return h.log
+	}
+	if l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log.Logger
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLoggerPrecedence(t *testing.T) {
+	defaultCtx := zerolog.DefaultContextLogger
+	t.Cleanup(func() { zerolog.DefaultContextLogger = defaultCtx })
+
+	client := zerolog.New(io.Discard).With().Str("from", "client").Logger()
+	scoped := zerolog.New(io.Discard).With().Str("from", "context").Logger()
+	fallback := zerolog.New(io.Discard).With().Str("from", "default").Logger()
+	zerolog.DefaultContextLogger = &fallback
+
+	withLogger := NewHTTPRetry(WithLogger(client))
+	plain := NewHTTPRetry()
+	background, withScoped := context.Background(), scoped.WithContext(context.Background())
+
+	tests := []struct {
+		name string
+		h    *HTTPRetry
+		ctx  context.Context
+		want *zerolog.Logger
+	}{
+		{name: "context logger wins", h: withLogger, ctx: withScoped, want: zerolog.Ctx(withScoped)},
+		{name: "client logger over default", h: withLogger, ctx: background, want: withLogger.log},
+		{name: "default context logger", h: plain, ctx: background, want: &fallback},
+	}
+	for _, tt := range tests {
+		if got := tt.h.logger(tt.ctx); got != tt.want {
+			t.Errorf("%s: got a different logger", tt.name)
+		}
+	}
+
+	zerolog.DefaultContextLogger = nil
+	if got := plain.logger(background); got != &log.Logger {
+		t.Error("global logger not used as the last resort")
+	}
+}
@@ -0,0 +1,210 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrOperationFailed = errors.New("long-running operation failed")
+
+// operationStatusPeek is how much of a status response is read for Done.
+const operationStatusPeek = 1 << 20
+
+// OperationFailedError is returned when the status of a long-running
+// operation reports that it failed or was canceled.
+type OperationFailedError struct {
+	StatusURL string
+	Status    string
+	Body      []byte
+}
+
+func (e *OperationFailedError) Error() string {
+	return fmt.Sprintf("long-running operation %s ended with status %s", e.StatusURL, e.Status)
+}
+
+func (e *OperationFailedError) Is(target error) bool {
+	return target == ErrOperationFailed
+}
+
+// LongRunning configures how WaitForOperation polls an operation.
+type LongRunning struct {
+	// Done reports whether a status response is terminal, returning an
+	// error for failed operations. body holds at most the first MiB of the
+	// response; the response's own Body still reads all of it. It defaults
+	// to DefaultOperationDone.
+	Done func(resp *http.Response, body []byte) (bool, error)
+	// PollInterval is used when a status response has no Retry-After and
+	// defaults to one second.
+	PollInterval time.Duration
+	// MaxPollInterval caps Retry-After and defaults to one minute.
+	MaxPollInterval time.Duration
+	// Header is sent with every poll and with the final fetch.
+	Header http.Header
+}
+
+// DefaultOperationDone treats a status body with a "status" field of
+// succeeded, failed or canceled (in any case) as terminal, and otherwise any
+// status response other than 202 Accepted.
+func DefaultOperationDone(resp *http.Response, body []byte) (bool, error) {
+	var s struct {
+		Status string `json:"status"`
+	}
+	if json.Unmarshal(body, &s) == nil && s.Status != "" {
+		switch strings.ToLower(s.Status) {
+		case "succeeded", "success", "completed":
+			return true, nil
+		case "failed", "canceled", "cancelled":
+			return true, &OperationFailedError{StatusURL: RedactURL(resp.Request.URL), Status: s.Status, Body: body}
+		}
+		return false, nil
+	}
+	return resp.StatusCode != http.StatusAccepted, nil
+}
+
+// WaitForOperation follows the operation started by resp, a 202 Accepted
+// response from Do, and returns the final resource. The status URL is taken
+// from Operation-Location, Azure-AsyncOperation or Location. Once the
+// operation is done the resource is fetched from the terminal status
+// response's Location, the original Location when polling used a separate
+// operation URL, or the original URL for PUT and PATCH; otherwise the
+// terminal status response is returned. Responses other than 202 are
+// returned unchanged. resp's body is closed.
+func (h *HTTPRetry) WaitForOperation(ctx context.Context, resp *http.Response, lro LongRunning) (*http.Response, error) {
+	if resp.StatusCode != http.StatusAccepted {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if lro.Done == nil {
+		lro.Done = DefaultOperationDone
+	}
+	if lro.PollInterval <= 0 {
+		lro.PollInterval = time.Second
+	}
+	if lro.MaxPollInterval <= 0 {
+		lro.MaxPollInterval = time.Minute
+	}
+
+	origin := resp.Request
+	statusURL, resourceURL, err := operationURLs(resp)
+	if err != nil {
+		return nil, err
+	}
+	wait := lro.retryAfter(resp)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		status, err := h.get(ctx, statusURL, lro.Header)
+		if err != nil {
+			return nil, err
+		}
+		if status.StatusCode >= 400 {
+			status.Body.Close()
+			return nil, newStatusError(status)
+		}
+		body, err := io.ReadAll(io.LimitReader(status.Body, operationStatusPeek))
+		if err != nil {
+			status.Body.Close()
+			return nil, err
+		}
+		// The terminal status response may be returned, so put back what
+		// was read in front of the rest of its body.
+		status.Body = &limitedBody{Reader: io.MultiReader(bytes.NewReader(body), status.Body), Closer: status.Body}
+		done, err := lro.Done(status, body)
+		if err != nil || !done {
+			status.Body.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !done {
+			wait = lro.retryAfter(status)
+			h.logger(ctx).Trace().Fields(map[string]interface{}{"URL": RedactURL(statusURL), "wait": wait.String()}).Msg("Operation in progress")
+			continue
+		}
+
+		final := resourceURL
+		if loc, err := status.Location(); err == nil && status.StatusCode != http.StatusAccepted {
+			final = loc
+		}
+		if final == nil && (origin.Method == http.MethodPut || origin.Method == http.MethodPatch) {
+			final = origin.URL
+		}
+		if final == nil || final.String() == status.Request.URL.String() {
+			return status, nil
+		}
+		status.Body.Close()
+		return h.get(ctx, final, lro.Header)
+	}
+}
+
+// operationURLs returns the URL to poll and, when the response names the
+// resource separately, the resource's URL.
+func operationURLs(resp *http.Response) (status, resource *url.URL, err error) {
+	base := resp.Request.URL
+	resolve := func(name string) (*url.URL, error) {
+		v := resp.Header.Get(name)
+		if v == "" {
+			return nil, nil //nolint:nilnil // header not present
+		}
+		u, err := base.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", name, err)
+		}
+		return u, nil
+	}
+	location, err := resolve("Location")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range []string{"Operation-Location", "Azure-AsyncOperation"} {
+		if status, err = resolve(name); status != nil || err != nil {
+			return status, location, err
+		}
+	}
+	if location == nil {
+		return nil, nil, errors.New("202 response has no operation status URL")
+	}
+	return location, nil, nil
+}
+
+func (lro *LongRunning) retryAfter(resp *http.Response) time.Duration {
+	wait := lro.PollInterval
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			wait = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			wait = time.Until(t)
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > lro.MaxPollInterval {
+		wait = lro.MaxPollInterval
+	}
+	return wait
+}
+
+func (h *HTTPRetry) get(ctx context.Context, u *url.URL, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeader(req.Header, header)
+	return h.Do(req)
+}
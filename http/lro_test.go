@@ -0,0 +1,43 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitForOperationReturnsFullTerminalBody(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), operationStatusPeek+1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			w.Header().Set("Location", "/status")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write(large)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/start", nil)
+	resp, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = h.WaitForOperation(context.Background(), resp, LongRunning{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != len(large) {
+		t.Fatalf("read %d bytes of the terminal status body, want %d", len(body), len(large))
+	}
+}
@@ -0,0 +1,299 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOfflineQueueFull   = errors.New("offline request queue full")
+	ErrOfflineQueueClosed = errors.New("offline request queue closed")
+	ErrRequestExpired     = errors.New("request expired in offline queue")
+)
+
+// OfflineQueue holds requests while the network is unreachable. When a
+// request fails and Probe fails too, the client goes offline: that request
+// and every later one are queued, and Probe is retried every ProbeInterval.
+// Once it succeeds the queue is sent in order, one request at a time, and
+// the client goes back online when the queue is empty. Do blocks until the
+// queued request has been sent.
+type OfflineQueue struct {
+	// Probe reports whether the network is reachable. See HTTPProbe.
+	Probe func(ctx context.Context) error
+	// ProbeInterval defaults to five seconds.
+	ProbeInterval time.Duration
+	// Capacity is the number of requests queued before Do fails with
+	// ErrOfflineQueueFull and defaults to 100.
+	Capacity int
+	// TTL is how long a request may wait in the queue before Do fails with
+	// ErrRequestExpired and defaults to five minutes. Override it per
+	// request with ContextWithOfflineTTL.
+	TTL time.Duration
+}
+
+func WithOfflineQueue(q OfflineQueue) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if q.ProbeInterval <= 0 {
+			q.ProbeInterval = 5 * time.Second
+		}
+		if q.Capacity <= 0 {
+			q.Capacity = 100
+		}
+		if q.TTL <= 0 {
+			q.TTL = 5 * time.Minute
+		}
+		h.offline = &offlineQueue{config: q, h: h, stop: make(chan struct{})}
+	}
+}
+
+// HTTPProbe returns a probe that succeeds when a HEAD request to url gets any
+// response within five seconds.
+func HTTPProbe(url string) func(ctx context.Context) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+}
+
+type offlineTTLKey struct{}
+
+// ContextWithOfflineTTL overrides the offline queue's TTL for requests
+// carrying the returned context.
+func ContextWithOfflineTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, offlineTTLKey{}, ttl)
+}
+
+type queuedState int
+
+const (
+	queuedWaiting queuedState = iota
+	queuedSending
+	queuedDelivered
+	queuedAbandoned
+)
+
+type queuedRequest struct {
+	req     *http.Request
+	expires time.Time
+	state   queuedState
+	result  chan queuedResult
+}
+
+type queuedResult struct {
+	resp *http.Response
+	err  error
+}
+
+type offlineQueue struct {
+	config OfflineQueue
+	h      *HTTPRetry
+	stop   chan struct{}
+
+	mu      sync.Mutex
+	offline bool
+	closed  bool
+	queue   []*queuedRequest
+}
+
+// do sends req with send unless the client is offline, in which case req is
+// queued until it can be sent.
+func (q *offlineQueue) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	q.mu.Lock()
+	offline, closed := q.offline, q.closed
+	q.mu.Unlock()
+	if closed {
+		return nil, ErrOfflineQueueClosed
+	}
+	if !offline {
+		resp, err := send(req)
+		if err == nil || !q.connectivityLost(req.Context(), err) {
+			return resp, err
+		}
+	}
+	return q.wait(req, send)
+}
+
+// connectivityLost reports whether err, from a request that was not
+// canceled by its caller, coincides with a failing probe. It takes the
+// client offline if so.
+func (q *offlineQueue) connectivityLost(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrBlockedDestination) || errors.Is(err, ErrRedirectBlocked) || errors.Is(err, ErrSSRFTransport) {
+		return false
+	}
+	if probeErr := q.probe(); probeErr == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.offline && !q.closed {
+		q.offline = true
+		q.h.logger(ctx).Warn().Fields(map[string]interface{}{"err": err}).Msg("Connectivity lost, queueing requests")
+		go q.run()
+	}
+	return true
+}
+
+func (q *offlineQueue) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.ProbeInterval)
+	defer cancel()
+	return q.config.Probe(ctx)
+}
+
+// wait queues req until it has been sent. If the queue was flushed since the
+// caller saw the client offline, req is sent directly instead.
+func (q *offlineQueue) wait(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ttl := q.config.TTL
+	if v, ok := req.Context().Value(offlineTTLKey{}).(time.Duration); ok {
+		ttl = v
+	}
+	item := &queuedRequest{req: req, expires: time.Now().Add(ttl), result: make(chan queuedResult, 1)}
+
+	q.mu.Lock()
+	switch {
+	case q.closed:
+		q.mu.Unlock()
+		return nil, ErrOfflineQueueClosed
+	case !q.offline:
+		q.mu.Unlock()
+		return send(req)
+	case len(q.queue) >= q.config.Capacity:
+		q.mu.Unlock()
+		return nil, ErrOfflineQueueFull
+	}
+	q.queue = append(q.queue, item)
+	q.mu.Unlock()
+
+	expired := time.NewTimer(ttl)
+	defer expired.Stop()
+	var err error
+	select {
+	case r := <-item.result:
+		return r.resp, r.err
+	case <-req.Context().Done():
+		err = req.Context().Err()
+	case <-expired.C:
+		err = ErrRequestExpired
+	}
+	if q.abandon(item) {
+		return nil, err
+	}
+	r := <-item.result
+	return r.resp, r.err
+}
+
+// abandon gives up on item unless it is already being sent, in which case
+// its result is waited for.
+func (q *offlineQueue) abandon(item *queuedRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if item.state == queuedWaiting {
+		item.state = queuedAbandoned
+		return true
+	}
+	return false
+}
+
+// run probes until connectivity returns and then drains the queue, going
+// back to probing when a queued request fails for lack of connectivity.
+func (q *offlineQueue) run() {
+	ticker := time.NewTicker(q.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+		if q.probe() != nil {
+			continue
+		}
+		if q.drain() {
+			return
+		}
+	}
+}
+
+// drain sends queued requests in order. It returns true once the queue is
+// empty and the client is back online.
+func (q *offlineQueue) drain() bool {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return true
+		}
+		if len(q.queue) == 0 {
+			q.offline = false
+			q.mu.Unlock()
+			q.h.logger(context.Background()).Info().Msg("Connectivity restored, request queue flushed")
+			return true
+		}
+		item := q.queue[0]
+		if item.state == queuedAbandoned {
+			q.queue = q.queue[1:]
+			q.mu.Unlock()
+			continue
+		}
+		if time.Now().After(item.expires) {
+			q.queue = q.queue[1:]
+			item.state = queuedDelivered
+			item.result <- queuedResult{err: ErrRequestExpired}
+			q.mu.Unlock()
+			continue
+		}
+		item.state = queuedSending
+		q.mu.Unlock()
+
+		resp, err := q.h.send(item.req)
+		if err != nil && item.req.Context().Err() == nil && q.probe() != nil {
+			// Still offline: leave the request at the head of the queue.
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if q.closed {
+				item.state = queuedDelivered
+				item.result <- queuedResult{err: ErrOfflineQueueClosed}
+				return true
+			}
+			item.state = queuedWaiting
+			return false
+		}
+
+		q.mu.Lock()
+		item.state = queuedDelivered
+		item.result <- queuedResult{resp: resp, err: err}
+		if q.closed {
+			q.mu.Unlock()
+			return true
+		}
+		q.queue = q.queue[1:]
+		q.mu.Unlock()
+	}
+}
+
+func (q *offlineQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.stop)
+	for _, item := range q.queue {
+		if item.state == queuedWaiting {
+			item.state = queuedDelivered
+			item.result <- queuedResult{err: ErrOfflineQueueClosed}
+		}
+	}
+	q.queue = nil
+}
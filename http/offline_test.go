@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOfflineQueueWaitSendsWhenBackOnline(t *testing.T) {
+	h := NewHTTPRetry(WithOfflineQueue(OfflineQueue{Probe: func(context.Context) error { return nil }}))
+	defer h.Close()
+
+	// The client went offline and the queue was flushed before wait took
+	// the lock: nothing would ever drain the request if it were queued.
+	req, _ := http.NewRequest(http.MethodGet, "http://service.example", nil)
+	sent := false
+	_, err := h.offline.wait(req, func(*http.Request) (*http.Response, error) {
+		sent = true
+		return nil, nil
+	})
+	if err != nil || !sent {
+		t.Fatalf("wait returned %v without sending the request", err)
+	}
+}
+
+func TestOfflineQueueFlushOverlapsNewRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var down atomic.Bool
+	down.Store(true)
+	errDown := errors.New("network down")
+	h := NewHTTPRetry(WithRetries(1), WithOfflineQueue(OfflineQueue{
+		Probe: func(context.Context) error {
+			if down.Load() {
+				return errDown
+			}
+			return nil
+		},
+		ProbeInterval: time.Millisecond,
+		TTL:           5 * time.Second,
+	}))
+	defer h.Close()
+	if err := h.SetHTTPClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if down.Load() {
+			return nil, errDown
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() error {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := h.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	errs := make(chan error, 51)
+	go func() { errs <- get() }()
+	for {
+		h.offline.mu.Lock()
+		queued := len(h.offline.queue)
+		h.offline.mu.Unlock()
+		if queued > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- get()
+		}()
+		if i == 10 {
+			down.Store(false)
+		}
+	}
+	wg.Wait()
+	for i := 0; i < 51; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("request failed across the flush: %v", err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package common
+
+import (
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// OutlierDetection ejects endpoints that misbehave from rotation, in the
+// manner of Envoy's outlier detection. An endpoint is ejected after
+// Consecutive5xx failed attempts in a row, or when its success rate over an
+// Interval falls more than SuccessRateStdevFactor standard deviations below
+// the mean of its peers. Each repeated ejection lasts BaseEjectionTime
+// longer than the previous one.
+type OutlierDetection struct {
+	// Consecutive5xx defaults to 5. Transport errors count as 5xx.
+	Consecutive5xx int
+	// Interval defaults to 10s.
+	Interval time.Duration
+	// BaseEjectionTime defaults to 30s.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime defaults to 300s.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent defaults to 50. At least one endpoint may always be
+	// ejected, and at least one is always kept.
+	MaxEjectionPercent int
+	// SuccessRateMinimumHosts defaults to 3.
+	SuccessRateMinimumHosts int
+	// SuccessRateRequestVolume defaults to 20 attempts per interval.
+	SuccessRateRequestVolume int
+	// SuccessRateStdevFactor defaults to 1.9.
+	SuccessRateStdevFactor float64
+}
+
+type EndpointState struct {
+	URL            string     `json:"url"`
+	Ejected        bool       `json:"ejected"`
+	EjectedUntil   *time.Time `json:"ejectedUntil,omitempty"`
+	Ejections      int        `json:"ejections"`
+	Consecutive5xx int        `json:"consecutive5xx"`
+	// Attempts and Failures cover the current detection interval.
+	Attempts int `json:"attempts"`
+	Failures int `json:"failures"`
+}
+
+// WithEndpoints spreads attempts round-robin across the given base URLs. Each
+// attempt keeps the request's path and query but uses the scheme and host of
+// the chosen endpoint, so retries move on to the next endpoint.
+func WithEndpoints(endpoints ...string) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		pool := h.endpointPool()
+		for _, e := range endpoints {
+			u, err := url.Parse(e)
+			if err != nil || u.Host == "" {
+				log.Warn().Fields(map[string]interface{}{"endpoint": e, "err": err}).Msg("Ignoring invalid endpoint")
+				continue
+			}
+			pool.endpoints = append(pool.endpoints, &endpoint{url: u})
+		}
+	}
+}
+
+func WithOutlierDetection(o OutlierDetection) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if o.Consecutive5xx <= 0 {
+			o.Consecutive5xx = 5
+		}
+		if o.Interval <= 0 {
+			o.Interval = 10 * time.Second
+		}
+		if o.BaseEjectionTime <= 0 {
+			o.BaseEjectionTime = 30 * time.Second
+		}
+		if o.MaxEjectionTime <= 0 {
+			o.MaxEjectionTime = 300 * time.Second
+		}
+		if o.MaxEjectionPercent <= 0 {
+			o.MaxEjectionPercent = 50
+		}
+		if o.SuccessRateMinimumHosts <= 0 {
+			o.SuccessRateMinimumHosts = 3
+		}
+		if o.SuccessRateRequestVolume <= 0 {
+			o.SuccessRateRequestVolume = 20
+		}
+		if o.SuccessRateStdevFactor <= 0 {
+			o.SuccessRateStdevFactor = 1.9
+		}
+		pool := h.endpointPool()
+		pool.detection = &o
+		pool.lastEval = time.Now()
+	}
+}
+
+func (h *HTTPRetry) endpointPool() *endpointPool {
+	if h.endpoints == nil {
+		h.endpoints = &endpointPool{}
+	}
+	return h.endpoints
+}
+
+func (h *HTTPRetry) Endpoints() []EndpointState {
+	if h.endpoints == nil {
+		return nil
+	}
+	return h.endpoints.state()
+}
+
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	detection *OutlierDetection
+	lastEval  time.Time
+}
+
+type endpoint struct {
+	url *url.URL
+
+	consecutive5xx int
+	attempts       int
+	failures       int
+
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// pick returns the next endpoint that is not ejected. If every endpoint is
+// ejected it falls back to plain round-robin rather than failing.
+func (p *endpointPool) pick(logger *zerolog.Logger) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.endpoints) == 0 {
+		return nil
+	}
+	now := time.Now()
+	p.evaluateLocked(now, logger)
+	for i := 0; i < len(p.endpoints); i++ {
+		e := p.endpoints[(p.next+i)%len(p.endpoints)]
+		if !e.ejectedUntil.After(now) {
+			p.next = (p.next + i + 1) % len(p.endpoints)
+			return e
+		}
+	}
+	e := p.endpoints[p.next%len(p.endpoints)]
+	p.next = (p.next + 1) % len(p.endpoints)
+	return e
+}
+
+func (p *endpointPool) report(e *endpoint, failed bool, logger *zerolog.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.attempts++
+	if !failed {
+		e.consecutive5xx = 0
+		return
+	}
+	e.failures++
+	e.consecutive5xx++
+	if p.detection != nil && e.consecutive5xx >= p.detection.Consecutive5xx {
+		p.ejectLocked(e, time.Now(), "consecutive 5xx", logger)
+	}
+}
+
+// evaluateLocked runs success-rate detection once per interval and lets the
+// ejection multiplier of endpoints that stayed healthy decay.
+func (p *endpointPool) evaluateLocked(now time.Time, logger *zerolog.Logger) {
+	d := p.detection
+	if d == nil || now.Sub(p.lastEval) < d.Interval {
+		return
+	}
+	p.lastEval = now
+
+	var rates []float64
+	var candidates []*endpoint
+	for _, e := range p.endpoints {
+		if e.ejectedUntil.After(now) {
+			continue
+		}
+		if e.ejections > 0 && now.Sub(e.ejectedUntil) > d.Interval {
+			e.ejections--
+		}
+		if e.attempts >= d.SuccessRateRequestVolume {
+			rates = append(rates, float64(e.attempts-e.failures)/float64(e.attempts))
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) >= d.SuccessRateMinimumHosts {
+		var mean, variance float64
+		for _, r := range rates {
+			mean += r
+		}
+		mean /= float64(len(rates))
+		for _, r := range rates {
+			variance += (r - mean) * (r - mean)
+		}
+		threshold := mean - d.SuccessRateStdevFactor*math.Sqrt(variance/float64(len(rates)))
+		for i, e := range candidates {
+			if rates[i] < threshold {
+				p.ejectLocked(e, now, "success rate", logger)
+			}
+		}
+	}
+	for _, e := range p.endpoints {
+		e.attempts, e.failures = 0, 0
+	}
+}
+
+func (p *endpointPool) ejectLocked(e *endpoint, now time.Time, reason string, logger *zerolog.Logger) {
+	if e.ejectedUntil.After(now) {
+		return
+	}
+	ejected := 0
+	for _, other := range p.endpoints {
+		if other.ejectedUntil.After(now) {
+			ejected++
+		}
+	}
+	limit := len(p.endpoints) * p.detection.MaxEjectionPercent / 100
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > len(p.endpoints)-1 {
+		limit = len(p.endpoints) - 1
+	}
+	if ejected >= limit {
+		return
+	}
+
+	e.ejections++
+	duration := time.Duration(e.ejections) * p.detection.BaseEjectionTime
+	if duration > p.detection.MaxEjectionTime {
+		duration = p.detection.MaxEjectionTime
+	}
+	e.ejectedUntil = now.Add(duration)
+	e.consecutive5xx = 0
+	logger.Warn().Fields(map[string]interface{}{"endpoint": e.url.Redacted(), "reason": reason, "ejections": e.ejections, "duration": duration.String()}).Msg("Ejecting endpoint")
+}
+
+func (p *endpointPool) state() []EndpointState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	out := make([]EndpointState, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		s := EndpointState{
+			URL:            e.url.Redacted(),
+			Ejected:        e.ejectedUntil.After(now),
+			Ejections:      e.ejections,
+			Consecutive5xx: e.consecutive5xx,
+			Attempts:       e.attempts,
+			Failures:       e.failures,
+		}
+		if s.Ejected {
+			until := e.ejectedUntil
+			s.EjectedUntil = &until
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// endpointURL returns u rewritten onto e's scheme, host and base path.
+func endpointURL(e *endpoint, u *url.URL) *url.URL {
+	out := *u
+	out.Scheme = e.url.Scheme
+	out.Host = e.url.Host
+	if e.url.User != nil {
+		out.User = e.url.User
+	}
+	if base := strings.TrimSuffix(e.url.Path, "/"); base != "" {
+		out.Path = base + u.Path
+		out.RawPath = ""
+	}
+	return &out
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEndpointStatsAndEjectionLogging(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	var logs bytes.Buffer
+	h := NewHTTPRetry(
+		WithRetries(2),
+		WithBackoff(0),
+		WithLogger(zerolog.New(&logs)),
+		WithEndpoints(failing.URL, healthy.URL),
+		WithOutlierDetection(OutlierDetection{Consecutive5xx: 1}),
+		WithStats(0),
+	)
+	h.sleep.Sleeper = noSleep{}
+	req, _ := http.NewRequest(http.MethodGet, "http://service.example/x", nil)
+	resp, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	stats := h.Stats()
+	if len(stats) != 1 || stats[0].Host != "service.example" {
+		t.Fatalf("stats = %+v, want one entry for service.example", stats)
+	}
+	if s := stats[0]; s.Requests != 1 || s.Attempts != 2 || s.Retries != 1 {
+		t.Fatalf("stats = %+v, want 1 request, 2 attempts and 1 retry", s)
+	}
+	if !strings.Contains(logs.String(), "Ejecting endpoint") {
+		t.Fatalf("ejection not logged through the client's logger: %s", logs.String())
+	}
+}
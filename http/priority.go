@@ -0,0 +1,229 @@
+package common
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	numPriorities = int(PriorityHigh) + 1
+)
+
+type priorityKey struct{}
+
+// ContextWithPriority sets the priority requests carrying ctx wait with when
+// the client's rate limit or concurrency limit is saturated.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// WithDefaultPriority sets the priority of requests without one in their
+// context. Values outside PriorityLow..PriorityHigh are clamped.
+func WithDefaultPriority(p Priority) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		switch {
+		case p < PriorityLow:
+			p = PriorityLow
+		case p > PriorityHigh:
+			p = PriorityHigh
+		}
+		h.defaultPriority = p
+	}
+}
+
+// WithRateLimit limits attempts to rps per second with bursts of up to burst.
+func WithRateLimit(rps float64, burst int) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		g := h.requestGate()
+		g.rate = rps
+		g.burst = math.Max(float64(burst), 1)
+		g.tokens = g.burst
+		g.last = time.Now()
+	}
+}
+
+// WithMaxConcurrency limits the number of attempts in flight at once. An
+// attempt whose response is returned stays in flight until its body is
+// closed.
+func WithMaxConcurrency(n int) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.requestGate().maxInFlight = n
+	}
+}
+
+// WithPriorityWeights sets the relative share of queued slots each priority
+// receives. Lower priorities keep a share so they are not starved. The
+// defaults are 1, 4 and 16.
+func WithPriorityWeights(low, normal, high int) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.requestGate().weights = [numPriorities]int{low, normal, high}
+	}
+}
+
+func (h *HTTPRetry) requestGate() *requestGate {
+	if h.gate == nil {
+		h.gate = &requestGate{weights: [numPriorities]int{1, 4, 16}}
+	}
+	return h.gate
+}
+
+func (h *HTTPRetry) priority(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= PriorityLow && p <= PriorityHigh {
+		return p
+	}
+	return h.defaultPriority
+}
+
+// requestGate admits attempts through a token bucket and a concurrency limit.
+// Attempts that cannot be admitted immediately wait in per-priority FIFO
+// queues which are drained by smooth weighted round-robin.
+type requestGate struct {
+	mu sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	timer  *time.Timer
+
+	maxInFlight int
+	inFlight    int
+
+	weights [numPriorities]int
+	credits [numPriorities]int
+	queues  [numPriorities][]*gateWaiter
+}
+
+type gateWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func (g *requestGate) acquire(ctx context.Context, p Priority) error {
+	g.mu.Lock()
+	g.refillLocked()
+	if g.queuedLocked() == 0 && g.admittableLocked() {
+		g.admitLocked()
+		g.mu.Unlock()
+		return nil
+	}
+	w := &gateWaiter{ready: make(chan struct{})}
+	g.queues[p] = append(g.queues[p], w)
+	g.dispatchLocked()
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if w.granted {
+			g.mu.Unlock()
+			g.release()
+			return ctx.Err()
+		}
+		q := g.queues[p]
+		for i := range q {
+			if q[i] == w {
+				g.queues[p] = append(q[:i:i], q[i+1:]...)
+				break
+			}
+		}
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (g *requestGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	g.dispatchLocked()
+}
+
+func (g *requestGate) available() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refillLocked()
+	return g.tokens
+}
+
+func (g *requestGate) dispatchLocked() {
+	for g.queuedLocked() > 0 {
+		g.refillLocked()
+		if g.maxInFlight > 0 && g.inFlight >= g.maxInFlight {
+			return
+		}
+		if g.rate > 0 && g.tokens < 1 {
+			if g.timer == nil {
+				wait := time.Duration((1 - g.tokens) / g.rate * float64(time.Second))
+				g.timer = time.AfterFunc(wait, func() {
+					g.mu.Lock()
+					defer g.mu.Unlock()
+					g.timer = nil
+					g.dispatchLocked()
+				})
+			}
+			return
+		}
+
+		w := g.nextLocked()
+		g.admitLocked()
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+func (g *requestGate) nextLocked() *gateWaiter {
+	total, next := 0, -1
+	for p := range g.queues {
+		if len(g.queues[p]) == 0 {
+			continue
+		}
+		g.credits[p] += g.weights[p]
+		total += g.weights[p]
+		if next < 0 || g.credits[p] > g.credits[next] {
+			next = p
+		}
+	}
+	g.credits[next] -= total
+	w := g.queues[next][0]
+	g.queues[next] = g.queues[next][1:]
+	return w
+}
+
+func (g *requestGate) admittableLocked() bool {
+	return (g.maxInFlight <= 0 || g.inFlight < g.maxInFlight) && (g.rate <= 0 || g.tokens >= 1)
+}
+
+func (g *requestGate) admitLocked() {
+	g.inFlight++
+	if g.rate > 0 {
+		g.tokens--
+	}
+}
+
+func (g *requestGate) queuedLocked() int {
+	n := 0
+	for p := range g.queues {
+		n += len(g.queues[p])
+	}
+	return n
+}
+
+func (g *requestGate) refillLocked() {
+	if g.rate <= 0 {
+		return
+	}
+	now := time.Now()
+	g.tokens = math.Min(g.burst, g.tokens+now.Sub(g.last).Seconds()*g.rate)
+	g.last = now
+}
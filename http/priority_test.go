@@ -0,0 +1,108 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithDefaultPriorityClamps(t *testing.T) {
+	for _, p := range []Priority{-1, 5} {
+		h := NewHTTPRetry(WithDefaultPriority(p), WithMaxConcurrency(1))
+		if got := h.priority(context.Background()); got < PriorityLow || got > PriorityHigh {
+			t.Errorf("WithDefaultPriority(%d) gave priority %d", p, got)
+		}
+	}
+}
+
+func TestMaxConcurrencyHoldsSlotUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	h := NewHTTPRetry(WithMaxConcurrency(1), WithDefaultPriority(5))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	first, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := h.Do(req); err == nil {
+		t.Fatal("second request was admitted while the first body was open")
+	}
+
+	first.Body.Close()
+	first.Body.Close()
+	req, _ = http.NewRequest(http.MethodGet, srv.URL, nil)
+	second, err := h.Do(req)
+	if err != nil {
+		t.Fatalf("request after closing the body: %v", err)
+	}
+	second.Body.Close()
+}
+
+func TestSaturatedGateDequeuesByWeight(t *testing.T) {
+	h := NewHTTPRetry(WithMaxConcurrency(1))
+	g := h.gate
+	if err := g.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	const perPriority = 21
+	var (
+		mu    sync.Mutex
+		order []Priority
+		wg    sync.WaitGroup
+	)
+	for p := PriorityLow; p <= PriorityHigh; p++ {
+		for i := 0; i < perPriority; i++ {
+			wg.Add(1)
+			go func(p Priority) {
+				defer wg.Done()
+				if err := g.acquire(context.Background(), p); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				order = append(order, p)
+				mu.Unlock()
+				g.release()
+			}(p)
+		}
+	}
+	for {
+		g.mu.Lock()
+		queued := g.queuedLocked()
+		g.mu.Unlock()
+		if queued == numPriorities*perPriority {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	g.release()
+	wg.Wait()
+
+	if order[0] != PriorityHigh {
+		t.Errorf("first admitted priority = %d, want high", order[0])
+	}
+	// While every queue is non-empty, one round of 1+4+16 slots is shared
+	// according to the default weights.
+	var got [numPriorities]int
+	for _, p := range order[:21] {
+		got[p]++
+	}
+	if want := [numPriorities]int{1, 4, 16}; got != want {
+		t.Errorf("slots per priority in the first round = %v, want %v", got, want)
+	}
+	if len(order) != numPriorities*perPriority {
+		t.Errorf("%d waiters admitted, want %d", len(order), numPriorities*perPriority)
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
+
+var sensitiveParams = []string{
+	"access_token", "refresh_token", "id_token", "token", "code",
+	"api_key", "apikey", "key", "secret", "client_secret",
+	"password", "passwd", "sig", "signature",
+}
+
+// RedactURL returns u as a string with its password and the values of query
+// parameters that commonly carry credentials replaced.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	out := *u
+	if _, ok := out.User.Password(); ok {
+		out.User = url.UserPassword(out.User.Username(), redacted)
+	}
+	if out.RawQuery != "" {
+		q := out.Query()
+		changed := false
+		for name := range q {
+			if isSensitiveParam(name) {
+				q[name] = []string{redacted}
+				changed = true
+			}
+		}
+		if changed {
+			out.RawQuery = q.Encode()
+		}
+	}
+	return out.String()
+}
+
+// RedactHeaders returns a copy of header with credential-bearing values
+// replaced.
+func RedactHeaders(header http.Header) http.Header {
+	out := header.Clone()
+	for name := range out {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = []string{redacted}
+		}
+	}
+	return out
+}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range sensitiveParams {
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var ErrRedirectBlocked = errors.New("redirect blocked by policy")
+
+const defaultMaxRedirects = 10
+
+type RedirectPolicy struct {
+	// MaxRedirects defaults to 10. A negative value stops at the first
+	// redirect and returns the redirect response itself.
+	MaxRedirects int
+	SameHostOnly bool
+	// DisallowDowngrade rejects redirects from https to http.
+	DisallowDowngrade bool
+	// StripAuthCrossHost removes AuthHeaders whenever a redirect changes
+	// host or port. net/http alone only strips them when leaving the
+	// original domain and its subdomains.
+	StripAuthCrossHost bool
+	// AuthHeaders defaults to Authorization, Proxy-Authorization and Cookie.
+	AuthHeaders []string
+	// RecordChain makes the hops of each request available via RedirectChain.
+	RecordChain bool
+}
+
+func WithRedirectPolicy(policy RedirectPolicy) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		p := policy
+		if len(p.AuthHeaders) == 0 {
+			p.AuthHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+		}
+		switch {
+		case p.MaxRedirects < 0:
+			h.maxRedirects = 0
+		case p.MaxRedirects > 0:
+			h.maxRedirects = p.MaxRedirects
+		}
+		h.recordRedirects = p.RecordChain
+		h.addRedirectCheck(p.check)
+	}
+}
+
+func (p *RedirectPolicy) check(req *http.Request, via []*http.Request) error {
+	// net/http copies the original request's headers onto every hop, so
+	// hosts are compared against the original request rather than the
+	// previous hop.
+	orig, prev := via[0].URL, via[len(via)-1].URL
+	crossHost := !strings.EqualFold(orig.Host, req.URL.Host)
+	if p.SameHostOnly && crossHost {
+		return fmt.Errorf("%w: %s leaves host %s", ErrRedirectBlocked, req.URL.Redacted(), orig.Host)
+	}
+	if p.DisallowDowngrade && prev.Scheme == "https" && req.URL.Scheme == "http" {
+		return fmt.Errorf("%w: %s downgrades to http", ErrRedirectBlocked, req.URL.Redacted())
+	}
+	if p.StripAuthCrossHost && crossHost {
+		for _, header := range p.AuthHeaders {
+			req.Header.Del(header)
+		}
+	}
+	return nil
+}
+
+type redirectChainKey struct{}
+
+type redirectChain struct {
+	mu   sync.Mutex
+	urls []*url.URL
+}
+
+func (c *redirectChain) reset(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls = []*url.URL{u}
+}
+
+func (c *redirectChain) add(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls = append(c.urls, u)
+}
+
+func contextWithRedirectChain(ctx context.Context) (context.Context, *redirectChain) {
+	chain := &redirectChain{}
+	return context.WithValue(ctx, redirectChainKey{}, chain), chain
+}
+
+// RedirectChain returns the URLs visited to produce resp, starting with the
+// requested URL and ending with the final one. It is only populated when the
+// client was built with RedirectPolicy.RecordChain.
+func RedirectChain(resp *http.Response) []*url.URL {
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	chain, ok := resp.Request.Context().Value(redirectChainKey{}).(*redirectChain)
+	if !ok {
+		return nil
+	}
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	return append([]*url.URL(nil), chain.urls...)
+}
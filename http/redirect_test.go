@@ -0,0 +1,120 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer target.Close()
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cross":
+			http.Redirect(w, r, target.URL+"/final", http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, origin.URL+"/final", http.StatusFound)
+		default:
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name     string
+		policy   RedirectPolicy
+		path     string
+		wantErr  error
+		wantAuth string
+	}{
+		{name: "same host only allows same host", policy: RedirectPolicy{SameHostOnly: true}, path: "/same", wantAuth: "Bearer secret"},
+		{name: "same host only blocks cross host", policy: RedirectPolicy{SameHostOnly: true}, path: "/cross", wantErr: ErrRedirectBlocked},
+		// Both servers share 127.0.0.1, so net/http forwards Authorization
+		// across the port change on its own.
+		{name: "auth kept without stripping", policy: RedirectPolicy{}, path: "/cross", wantAuth: "Bearer secret"},
+		{name: "auth stripped cross host", policy: RedirectPolicy{StripAuthCrossHost: true}, path: "/cross", wantAuth: ""},
+		{name: "auth kept on same host", policy: RedirectPolicy{StripAuthCrossHost: true}, path: "/same", wantAuth: "Bearer secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPRetry(WithRedirectPolicy(tt.policy), WithRetries(1))
+			req, _ := http.NewRequest(http.MethodGet, origin.URL+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := h.Do(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Do error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantAuth {
+				t.Errorf("Authorization at the final hop = %q, want %q", body, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestRedirectPolicyDisallowDowngrade(t *testing.T) {
+	p := RedirectPolicy{DisallowDowngrade: true}
+	orig, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	for _, tt := range []struct {
+		next    string
+		wantErr error
+	}{
+		{next: "https://example.com/next", wantErr: nil},
+		{next: "http://example.com/next", wantErr: ErrRedirectBlocked},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.next, nil)
+		if err := p.check(req, []*http.Request{orig}); !errors.Is(err, tt.wantErr) {
+			t.Errorf("redirect to %s: error = %v, want %v", tt.next, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, srv.URL+"/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithRedirectPolicy(RedirectPolicy{RecordChain: true}))
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/a", nil)
+	resp, err := h.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var got []string
+	for _, u := range RedirectChain(resp) {
+		got = append(got, u.Path)
+	}
+	if want := []string{"/a", "/b", "/c"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("RedirectChain = %v, want %v", got, want)
+	}
+
+	plain := NewHTTPRetry()
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/a", nil)
+	resp, err = plain.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if chain := RedirectChain(resp); chain != nil {
+		t.Errorf("RedirectChain without RecordChain = %v, want nil", chain)
+	}
+}
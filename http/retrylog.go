@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetryLogSampling limits the retry warnings Do logs per host. Within each
+// Period the first Burst warnings for a host are logged, then every
+// Every-th one. At the end of a period in which warnings were suppressed, a
+// single summary line reports how many were dropped per host.
+type RetryLogSampling struct {
+	// Burst defaults to 5.
+	Burst int
+	// Period defaults to one minute.
+	Period time.Duration
+	// Every of zero suppresses all warnings beyond the burst.
+	Every int
+}
+
+func WithRetryLogSampling(s RetryLogSampling) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if s.Burst <= 0 {
+			s.Burst = 5
+		}
+		if s.Period <= 0 {
+			s.Period = time.Minute
+		}
+		h.retryLog = &retryLogSampler{config: s, hosts: map[string]*retryLogWindow{}, h: h}
+	}
+}
+
+type retryLogSampler struct {
+	config RetryLogSampling
+	h      *HTTPRetry
+
+	mu        sync.Mutex
+	hosts     map[string]*retryLogWindow
+	timer     *time.Timer
+	lastPrune time.Time
+	closed    bool
+}
+
+type retryLogWindow struct {
+	start      time.Time
+	seen       int
+	suppressed int
+}
+
+// allow reports whether a retry warning for host should be logged.
+func (s *retryLogSampler) allow(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastPrune) >= s.config.Period {
+		s.pruneLocked(now)
+	}
+	w, ok := s.hosts[host]
+	if !ok || now.Sub(w.start) >= s.config.Period {
+		suppressed := 0
+		if ok {
+			suppressed = w.suppressed
+		}
+		w = &retryLogWindow{start: now, suppressed: suppressed}
+		s.hosts[host] = w
+	}
+	w.seen++
+	if w.seen <= s.config.Burst {
+		return true
+	}
+	if s.config.Every > 0 && (w.seen-s.config.Burst)%s.config.Every == 0 {
+		return true
+	}
+	w.suppressed++
+	if s.timer == nil && !s.closed {
+		s.timer = time.AfterFunc(s.config.Period, s.summarize)
+	}
+	return false
+}
+
+// pruneLocked drops the windows of hosts that have been quiet for a period
+// and have nothing left to summarize, so that hosts seen once do not pile up.
+func (s *retryLogSampler) pruneLocked(now time.Time) {
+	for host, w := range s.hosts {
+		if w.suppressed == 0 && now.Sub(w.start) >= s.config.Period {
+			delete(s.hosts, host)
+		}
+	}
+	s.lastPrune = now
+}
+
+// close stops the summary timer and logs what is still suppressed.
+func (s *retryLogSampler) close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.summarize()
+}
+
+// summarize logs and resets the suppressed counts of every host.
+func (s *retryLogSampler) summarize() {
+	s.mu.Lock()
+	counts := map[string]interface{}{}
+	total := 0
+	for host, w := range s.hosts {
+		if w.suppressed > 0 {
+			counts[host] = w.suppressed
+			total += w.suppressed
+			w.suppressed = 0
+		}
+	}
+	s.pruneLocked(time.Now())
+	s.timer = nil
+	s.mu.Unlock()
+
+	if total == 0 {
+		return
+	}
+	s.h.logger(context.Background()).Warn().Fields(map[string]interface{}{
+		"suppressed": counts,
+		"total":      total,
+		"period":     s.config.Period.String(),
+	}).Msg("Suppressed Http Request Error warnings")
+}
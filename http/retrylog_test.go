@@ -0,0 +1,119 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// lockedBuffer lets the test read what the summary timer writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRetryLogSampling(t *testing.T) {
+	tests := []struct {
+		name  string
+		every int
+		want  string
+	}{
+		{name: "burst only", every: 0, want: "yyynnnnnnn"},
+		{name: "every third", every: 3, want: "yyynnynnyn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPRetry(WithRetryLogSampling(RetryLogSampling{Burst: 3, Every: tt.every, Period: time.Hour}))
+			defer h.Close()
+			var got strings.Builder
+			for i := 0; i < 10; i++ {
+				if h.retryLog.allow("a") {
+					got.WriteByte('y')
+				} else {
+					got.WriteByte('n')
+				}
+			}
+			if got.String() != tt.want {
+				t.Fatalf("allowed = %s, want %s", got.String(), tt.want)
+			}
+			// Other hosts have their own burst.
+			if !h.retryLog.allow("b") {
+				t.Fatal("first warning for another host suppressed")
+			}
+		})
+	}
+}
+
+func TestRetryLogSummary(t *testing.T) {
+	var out lockedBuffer
+	h := NewHTTPRetry(WithLogger(zerolog.New(&out)), WithRetryLogSampling(RetryLogSampling{Burst: 1, Period: 20 * time.Millisecond}))
+	defer h.Close()
+	for i := 0; i < 4; i++ {
+		h.retryLog.allow("a")
+	}
+	h.retryLog.allow("b")
+	h.retryLog.allow("b")
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), "Suppressed") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := out.String(); !strings.Contains(got, `"suppressed":{"a":3,"b":1}`) || !strings.Contains(got, `"total":4`) {
+		t.Fatalf("summary = %s", got)
+	}
+
+	// A new period starts with a fresh burst.
+	if !h.retryLog.allow("a") {
+		t.Fatal("warning suppressed in a new period")
+	}
+}
+
+func TestRetryLogPrunesQuietHosts(t *testing.T) {
+	h := NewHTTPRetry(WithRetryLogSampling(RetryLogSampling{Period: 10 * time.Millisecond}))
+	defer h.Close()
+	for i := 0; i < 100; i++ {
+		h.retryLog.allow(strings.Repeat("x", i+1))
+	}
+	time.Sleep(20 * time.Millisecond)
+	h.retryLog.allow("fresh")
+
+	h.retryLog.mu.Lock()
+	defer h.retryLog.mu.Unlock()
+	if n := len(h.retryLog.hosts); n != 1 {
+		t.Fatalf("%d hosts tracked, want only the fresh one", n)
+	}
+}
+
+func TestRetryLogCloseSummarizes(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHTTPRetry(WithLogger(zerolog.New(&out)), WithRetryLogSampling(RetryLogSampling{Burst: 1, Period: time.Hour}))
+	h.retryLog.allow("a")
+	h.retryLog.allow("a")
+	h.Close()
+
+	if !strings.Contains(out.String(), `"suppressed":{"a":1}`) {
+		t.Fatalf("Close did not summarize: %s", out.String())
+	}
+	h.retryLog.allow("a")
+	h.retryLog.mu.Lock()
+	defer h.retryLog.mu.Unlock()
+	if h.retryLog.timer != nil {
+		t.Fatal("summary timer running after Close")
+	}
+}
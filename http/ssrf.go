@@ -0,0 +1,217 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBlockedDestination = errors.New("destination blocked by ssrf policy")
+	ErrSSRFTransport      = errors.New("ssrf protection requires an *http.Transport")
+)
+
+type BlockedDestinationError struct {
+	Destination string
+	Reason      string
+}
+
+func (e *BlockedDestinationError) Error() string {
+	return fmt.Sprintf("destination %s blocked by ssrf policy: %s", e.Destination, e.Reason)
+}
+
+func (e *BlockedDestinationError) Is(target error) bool {
+	return target == ErrBlockedDestination
+}
+
+// SSRFPolicy restricts the destinations HTTPRetry may connect to. Addresses
+// are checked after DNS resolution, at dial time, so a hostname cannot be
+// rebound to an internal address between validation and connection.
+type SSRFPolicy struct {
+	// AllowedSchemes defaults to http and https.
+	AllowedSchemes []string
+	// AllowedPorts defaults to 80 and 443.
+	AllowedPorts []int
+	// AllowedNetworks are exempted from the blocked ranges.
+	AllowedNetworks []*net.IPNet
+	// BlockedNetworks are blocked in addition to the default ranges.
+	BlockedNetworks []*net.IPNet
+}
+
+var defaultBlockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",          // "this" network
+	"10.0.0.0/8",         // private
+	"100.64.0.0/10",      // carrier-grade NAT
+	"127.0.0.0/8",        // loopback
+	"169.254.0.0/16",     // link-local, cloud metadata
+	"172.16.0.0/12",      // private
+	"192.0.0.0/24",       // IETF protocol assignments
+	"192.0.2.0/24",       // documentation
+	"192.168.0.0/16",     // private
+	"198.18.0.0/15",      // benchmarking
+	"198.51.100.0/24",    // documentation
+	"203.0.113.0/24",     // documentation
+	"224.0.0.0/4",        // multicast
+	"240.0.0.0/4",        // reserved, broadcast
+	"100.100.100.200/32", // Alibaba Cloud metadata
+	"::/128",             // unspecified
+	"::1/128",            // loopback
+	"64:ff9b::/96",       // NAT64, may embed internal IPv4
+	"100::/64",           // discard
+	"2001:db8::/32",      // documentation
+	"fc00::/7",           // unique local, includes fd00:ec2::254 metadata
+	"fe80::/10",          // link-local
+	"ff00::/8",           // multicast
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// WithSSRFProtection installs a dialer enforcing policy and validates the
+// scheme and port of the request URL and of every redirect hop. Proxies are
+// disabled because the dialer would only ever see the proxy's address. A
+// client whose transport is not an *http.Transport fails every request with
+// ErrSSRFTransport rather than going unprotected.
+func WithSSRFProtection(policy SSRFPolicy) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		p := policy
+		if len(p.AllowedSchemes) == 0 {
+			p.AllowedSchemes = []string{"http", "https"}
+		}
+		if len(p.AllowedPorts) == 0 {
+			p.AllowedPorts = []int{80, 443}
+		}
+		h.ssrf = &p
+
+		rt := h.HTTPClient.Transport
+		if t := h.transport(); t != nil {
+			p.protect(t)
+		} else {
+			h.HTTPClient.Transport = rejectTransport{err: fmt.Errorf("%w, got %T", ErrSSRFTransport, rt)}
+		}
+		h.addRedirectCheck(func(req *http.Request, _ []*http.Request) error {
+			return p.checkURL(req.URL)
+		})
+	}
+}
+
+func (p *SSRFPolicy) protect(t *http.Transport) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	t.Proxy = nil
+	t.DialContext = dialer.DialContext
+}
+
+// rejectTransport fails every request with err.
+type rejectTransport struct {
+	err error
+}
+
+func (t rejectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeRequestBody(req)
+	return nil, t.err
+}
+
+func (p *SSRFPolicy) checkURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if !containsString(p.AllowedSchemes, scheme) {
+		return &BlockedDestinationError{Destination: u.Redacted(), Reason: "scheme not allowed"}
+	}
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if !p.portAllowed(port) {
+		return &BlockedDestinationError{Destination: u.Redacted(), Reason: "port not allowed"}
+	}
+	return nil
+}
+
+func (p *SSRFPolicy) control(_, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !p.portAllowed(port) {
+		return &BlockedDestinationError{Destination: address, Reason: "port not allowed"}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &BlockedDestinationError{Destination: address, Reason: "unresolved address"}
+	}
+	if !p.ipAllowed(ip) {
+		return &BlockedDestinationError{Destination: address, Reason: "address in blocked range"}
+	}
+	return nil
+}
+
+func (p *SSRFPolicy) portAllowed(port string) bool {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range p.AllowedPorts {
+		if n == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SSRFPolicy) ipAllowed(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range p.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range defaultBlockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	for _, n := range p.BlockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
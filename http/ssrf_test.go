@@ -0,0 +1,160 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSSRFPolicyIPAllowed(t *testing.T) {
+	p := &SSRFPolicy{
+		AllowedNetworks: mustParseCIDRs("10.1.2.0/24"),
+		BlockedNetworks: mustParseCIDRs("93.184.216.0/24"),
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.0.0.2", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"ff02::1", false},
+		{"64:ff9b::a00:1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		if got := p.ipAllowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ipAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestSSRFPolicyCheckURL(t *testing.T) {
+	p := &SSRFPolicy{AllowedSchemes: []string{"http", "https"}, AllowedPorts: []int{80, 443, 8443}}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/", true},
+		{"http://example.com/", true},
+		{"HTTPS://example.com/", true},
+		{"https://example.com:8443/", true},
+		{"http://example.com:443/", true},
+		{"https://example.com:22/", false},
+		{"http://example.com:8080/", false},
+		{"ftp://example.com/", false},
+		{"file:///etc/passwd", false},
+		{"gopher://example.com:70/", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.checkURL(u)
+		if got := err == nil; got != tt.want {
+			t.Errorf("checkURL(%s) = %v, want allowed %v", tt.url, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrBlockedDestination) {
+			t.Errorf("checkURL(%s) error %v does not match ErrBlockedDestination", tt.url, err)
+		}
+	}
+}
+
+func TestSSRFProtectionDial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	// localhost passes the URL checks and is only caught once resolved, at
+	// dial time.
+	h := NewHTTPRetry(WithRetries(1), WithSSRFProtection(SSRFPolicy{AllowedPorts: []int{port}}))
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:"+strconv.Itoa(port)+"/", nil)
+	if _, err := h.Do(req); !errors.Is(err, ErrBlockedDestination) {
+		t.Fatalf("Do(localhost) error = %v, want ErrBlockedDestination", err)
+	}
+}
+
+func TestSSRFProtectionRedirect(t *testing.T) {
+	var hits atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer target.Close()
+	targetPort := target.Listener.Addr().(*net.TCPAddr).Port
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name            string
+		to              string
+		allowTargetPort bool
+	}{
+		{name: "port not allowed", to: "http://127.0.0.1:" + strconv.Itoa(targetPort) + "/"},
+		{name: "scheme not allowed", to: "ftp://127.0.0.1/"},
+		{name: "loopback outside allowed network", to: "http://127.0.0.2:" + strconv.Itoa(targetPort) + "/", allowTargetPort: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowedPorts := []int{port}
+			if tt.allowTargetPort {
+				allowedPorts = append(allowedPorts, targetPort)
+			}
+			h := NewHTTPRetry(WithRetries(1), WithSSRFProtection(SSRFPolicy{
+				AllowedPorts:    allowedPorts,
+				AllowedNetworks: mustParseCIDRs("127.0.0.1/32"),
+			}))
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/?to="+url.QueryEscape(tt.to), nil)
+			if _, err := h.Do(req); !errors.Is(err, ErrBlockedDestination) {
+				t.Fatalf("Do error = %v, want ErrBlockedDestination", err)
+			}
+		})
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("redirect target was reached %d times", n)
+	}
+}
+
+func TestSSRFProtectionFailsClosed(t *testing.T) {
+	hits := 0
+	custom := func(h *HTTPRetry) {
+		h.HTTPClient.Transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			hits++
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		})
+	}
+	h := NewHTTPRetry(custom, WithSSRFProtection(SSRFPolicy{}))
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if _, err := h.Do(req); !errors.Is(err, ErrSSRFTransport) {
+		t.Fatalf("err = %v, want ErrSSRFTransport", err)
+	}
+	if hits != 0 {
+		t.Fatalf("unprotected transport used %d times", hits)
+	}
+}
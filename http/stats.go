@@ -0,0 +1,226 @@
+package common
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	recentFailures  = 20
+	defaultMaxHosts = 1000
+)
+
+type HostStats struct {
+	Host      string `json:"host"`
+	Requests  int64  `json:"requests"`
+	Successes int64  `json:"successes"`
+	Failures  int64  `json:"failures"`
+	Attempts  int64  `json:"attempts"`
+	Retries   int64  `json:"retries"`
+	// SuccessRate is the share of requests that succeeded.
+	SuccessRate float64 `json:"successRate"`
+	// RetryRate is the share of attempts that were retries.
+	RetryRate      float64            `json:"retryRate"`
+	Latency        LatencyPercentiles `json:"latency"`
+	RecentFailures []FailureRecord    `json:"recentFailures"`
+}
+
+// LatencyPercentiles are the times to response headers of attempts that got
+// a response; in JSON they are nanoseconds.
+type LatencyPercentiles struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+	// Count is the decayed number of samples the percentiles are drawn from.
+	Count float64 `json:"count"`
+}
+
+// FailureRecord describes a failed attempt.
+type FailureRecord struct {
+	Time       time.Time `json:"time"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// statsRegistry methods are no-ops on a nil registry, which is what clients
+// without WithStats have.
+type statsRegistry struct {
+	mu       sync.Mutex
+	hosts    map[string]*hostStats
+	halfLife time.Duration
+	maxHosts int
+}
+
+type hostStats struct {
+	requests, successes, failures, attempts, retries int64
+	used                                             time.Time
+
+	latency *latencyHistogram
+
+	recent  [recentFailures]FailureRecord
+	recentN int
+}
+
+// WithStats collects the per-host statistics behind Stats,
+// LatencyPercentiles and the dashboard. At most maxHosts hosts are tracked,
+// 1000 by default; beyond that the host used least recently is dropped.
+func WithStats(maxHosts int) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		if maxHosts <= 0 {
+			maxHosts = defaultMaxHosts
+		}
+		h.statsRegistry().maxHosts = maxHosts
+	}
+}
+
+func (h *HTTPRetry) statsRegistry() *statsRegistry {
+	if h.stats == nil {
+		h.stats = &statsRegistry{hosts: map[string]*hostStats{}, halfLife: defaultLatencyHalfLife, maxHosts: defaultMaxHosts}
+	}
+	return h.stats
+}
+
+func (r *statsRegistry) hostLocked(host string) *hostStats {
+	now := time.Now()
+	s, ok := r.hosts[host]
+	if !ok {
+		if len(r.hosts) >= r.maxHosts {
+			r.evictLocked()
+		}
+		s = &hostStats{latency: newLatencyHistogram(r.halfLife, now)}
+		r.hosts[host] = s
+	}
+	s.used = now
+	return s
+}
+
+func (r *statsRegistry) evictLocked() {
+	var oldest string
+	var used time.Time
+	for host, s := range r.hosts {
+		if oldest == "" || s.used.Before(used) {
+			oldest, used = host, s.used
+		}
+	}
+	delete(r.hosts, oldest)
+}
+
+// attempt records an attempt under host, the request's own host, so that
+// attempts sent to other endpoints count towards the same stats as the
+// request. u is the URL actually tried.
+func (r *statsRegistry) attempt(host string, u *url.URL, latency time.Duration, resp *http.Response, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.hostLocked(host)
+	s.attempts++
+	// Only attempts that got response headers say how long the host takes
+	// to answer: timeouts would push the adaptive timeout up and refused
+	// connections pull it down.
+	if err == nil {
+		s.latency.record(latency, time.Now())
+	}
+
+	if err == nil && resp.StatusCode < 500 {
+		return
+	}
+	f := FailureRecord{Time: time.Now(), URL: u.Redacted()}
+	if resp != nil {
+		f.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		f.Error = err.Error()
+	}
+	s.recent[s.recentN%recentFailures] = f
+	s.recentN++
+}
+
+func (r *statsRegistry) request(host string, attempts int, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.hostLocked(host)
+	s.requests++
+	if err == nil {
+		s.successes++
+	} else {
+		s.failures++
+	}
+	if attempts > 1 {
+		s.retries += int64(attempts - 1)
+	}
+}
+
+func (r *statsRegistry) snapshot() []HostStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	out := make([]HostStats, 0, len(r.hosts))
+	for host, s := range r.hosts {
+		hs := HostStats{
+			Host:      host,
+			Requests:  s.requests,
+			Successes: s.successes,
+			Failures:  s.failures,
+			Attempts:  s.attempts,
+			Retries:   s.retries,
+			Latency:   s.latency.percentiles(now),
+		}
+		if hs.Requests > 0 {
+			hs.SuccessRate = float64(hs.Successes) / float64(hs.Requests)
+		}
+		if hs.Attempts > 0 {
+			hs.RetryRate = float64(hs.Retries) / float64(hs.Attempts)
+		}
+		for i := s.recentN - 1; i >= 0 && i >= s.recentN-recentFailures; i-- {
+			hs.RecentFailures = append(hs.RecentFailures, s.recent[i%recentFailures])
+		}
+		out = append(out, hs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// Stats returns per-host request statistics, sorted by host. It is empty
+// unless the client was built WithStats.
+func (h *HTTPRetry) Stats() []HostStats {
+	return h.stats.snapshot()
+}
+
+// LatencyPercentiles returns the decayed per-attempt latency percentiles for
+// host, as used in request URLs (host or host:port).
+func (h *HTTPRetry) LatencyPercentiles(host string) (LatencyPercentiles, bool) {
+	return h.stats.percentiles(host)
+}
+
+func (r *statsRegistry) percentiles(host string) (LatencyPercentiles, bool) {
+	if r == nil {
+		return LatencyPercentiles{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.hosts[host]
+	if !ok {
+		return LatencyPercentiles{}, false
+	}
+	return s.latency.percentiles(time.Now()), true
+}
+
+// WithLatencyHalfLife sets how quickly old samples fade from the latency
+// percentiles. Zero keeps every sample at full weight. It enables WithStats.
+func WithLatencyHalfLife(halfLife time.Duration) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.statsRegistry().halfLife = halfLife
+	}
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatsOptIn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name    string
+		options []func(*HTTPRetry)
+		want    int
+	}{
+		{name: "disabled by default", want: 0},
+		{name: "enabled", options: []func(*HTTPRetry){WithStats(0)}, want: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPRetry(tt.options...)
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			resp, err := h.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := len(h.Stats()); got != tt.want {
+				t.Fatalf("%d hosts tracked, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsEvictsLeastRecentlyUsedHost(t *testing.T) {
+	h := NewHTTPRetry(WithStats(2))
+	h.stats.request("a", 1, nil)
+	h.stats.request("b", 1, nil)
+	h.stats.request("a", 1, nil)
+	h.stats.request("c", 1, nil)
+
+	var hosts []string
+	for _, s := range h.Stats() {
+		hosts = append(hosts, s.Host)
+	}
+	if got := strings.Join(hosts, ","); got != "a,c" {
+		t.Fatalf("hosts = %s, want a,c", got)
+	}
+}
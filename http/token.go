@@ -0,0 +1,63 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Token is an OAuth 2.0 style access token.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid reports whether t has an access token that does not expire within
+// leeway.
+func (t *Token) Valid(leeway time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > leeway)
+}
+
+// TokenSource supplies the token sent with each attempt. Implementations
+// must be safe for concurrent use and should cache tokens themselves.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// WithTokenSource sets the Authorization header of every attempt from ts, so
+// that a retry after a long backoff picks up a refreshed token. Requests the
+// caller gave an Authorization header keep it.
+func WithTokenSource(ts TokenSource) func(httpRetry *HTTPRetry) {
+	return func(h *HTTPRetry) {
+		h.tokens = ts
+	}
+}
+
+type withoutTokenKey struct{}
+
+// ContextWithoutTokenSource sends requests carrying the returned context
+// without a token from the client's TokenSource, so that a token source can
+// fetch its tokens through the client it serves.
+func ContextWithoutTokenSource(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutTokenKey{}, true)
+}
+
+// authorize leaves requests that already carry an Authorization header, such
+// as one set by SetBasicAuth, alone.
+func (h *HTTPRetry) authorize(req *http.Request) error {
+	if h.tokens == nil || req.Header.Get("Authorization") != "" || req.Context().Value(withoutTokenKey{}) != nil {
+		return nil
+	}
+	tok, err := h.tokens.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	typ := tok.TokenType
+	if typ == "" || http.CanonicalHeaderKey(typ) == "Bearer" {
+		typ = "Bearer"
+	}
+	req.Header.Set("Authorization", typ+" "+tok.AccessToken)
+	return nil
+}
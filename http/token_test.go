@@ -0,0 +1,71 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type staticTokenSource Token
+
+func (s *staticTokenSource) Token(context.Context) (*Token, error) {
+	tok := Token(*s)
+	return &tok, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithTokenSource(&staticTokenSource{AccessToken: "tok"}))
+	tests := []struct {
+		name    string
+		prepare func(req *http.Request) *http.Request
+		want    string
+	}{
+		{name: "token", prepare: func(req *http.Request) *http.Request { return req }, want: "Bearer tok"},
+		{name: "basic auth kept", prepare: func(req *http.Request) *http.Request {
+			req.SetBasicAuth("user", "pass")
+			return req
+		}, want: "Basic dXNlcjpwYXNz"},
+		{name: "without token source", prepare: func(req *http.Request) *http.Request {
+			return req.WithContext(ContextWithoutTokenSource(req.Context()))
+		}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			resp, err := h.Do(tt.prepare(req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var buf [64]byte
+			n, _ := resp.Body.Read(buf[:])
+			if got := string(buf[:n]); got != tt.want {
+				t.Fatalf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextWithRetries(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPRetry(WithRetries(3), WithBackoff(0))
+	req, _ := http.NewRequestWithContext(ContextWithRetries(context.Background(), 1), http.MethodPost, srv.URL, nil)
+	if _, err := h.Do(req); err == nil {
+		t.Fatal("expected an error")
+	}
+	if hits != 1 {
+		t.Fatalf("%d attempts, want 1", hits)
+	}
+}
@@ -0,0 +1,144 @@
+// Package logging builds zerolog loggers from configuration so services set
+// up levels, output format, sampling and static fields the same way.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type Format string
+
+const (
+	FormatJSON    Format = "json"
+	FormatConsole Format = "console"
+)
+
+type Config struct {
+	// Format defaults to JSON.
+	Format Format
+	// Level is a zerolog level name such as "debug" or "warn" and defaults
+	// to info.
+	Level string
+	// Caller adds the file and line of the logging call.
+	Caller bool
+	// Sampling, when set, drops events beyond a burst per period.
+	Sampling *Sampling
+	// Service and Version are added to every event when set.
+	Service string
+	Version string
+	// Fields are added to every event.
+	Fields map[string]interface{}
+	// Output defaults to stderr.
+	Output io.Writer
+	// TimeFormat formats timestamps. The console format defaults to RFC 3339
+	// and JSON to zerolog.TimeFieldFormat.
+	TimeFormat string
+}
+
+// Sampling lets Burst events through per Period and then every Every-th
+// event until the period ends. Every of zero drops the rest.
+type Sampling struct {
+	Burst  uint32
+	Period time.Duration
+	Every  uint32
+}
+
+// New builds a logger from cfg.
+func New(cfg Config) (zerolog.Logger, error) {
+	level := zerolog.InfoLevel
+	if cfg.Level != "" {
+		var err error
+		if level, err = zerolog.ParseLevel(strings.ToLower(cfg.Level)); err != nil {
+			return zerolog.Nop(), fmt.Errorf("logging: %w", err)
+		}
+	}
+
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	timeFormat := cfg.TimeFormat
+	if timeFormat == "" {
+		timeFormat = time.RFC3339
+	}
+	switch cfg.Format {
+	case "", FormatJSON:
+	case FormatConsole:
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: timeFormat}
+	default:
+		return zerolog.Nop(), fmt.Errorf("logging: unknown format %q", cfg.Format)
+	}
+
+	// zerolog.TimeFieldFormat is global, so a JSON time format of our own is
+	// applied by a hook instead of Timestamp.
+	c := zerolog.New(out).Level(level).With()
+	jsonTimeFormat := cfg.Format != FormatConsole && cfg.TimeFormat != ""
+	if !jsonTimeFormat {
+		c = c.Timestamp()
+	}
+	if cfg.Caller {
+		c = c.Caller()
+	}
+	if cfg.Service != "" {
+		c = c.Str("service", cfg.Service)
+	}
+	if cfg.Version != "" {
+		c = c.Str("version", cfg.Version)
+	}
+	if len(cfg.Fields) > 0 {
+		c = c.Fields(cfg.Fields)
+	}
+	logger := c.Logger()
+	if jsonTimeFormat {
+		logger = logger.Hook(timestampHook(cfg.TimeFormat))
+	}
+
+	if s := cfg.Sampling; s != nil {
+		sampler := &zerolog.BurstSampler{Burst: s.Burst, Period: s.Period}
+		if s.Every > 0 {
+			sampler.NextSampler = &zerolog.BasicSampler{N: s.Every}
+		}
+		logger = logger.Sample(sampler)
+	}
+	return logger, nil
+}
+
+// Setup builds a logger from cfg and installs it as the global logger and as
+// the default for contexts without a logger of their own.
+func Setup(cfg Config) (zerolog.Logger, error) {
+	logger, err := New(cfg)
+	if err != nil {
+		return logger, err
+	}
+	log.Logger = logger
+	zerolog.DefaultContextLogger = &logger
+	return logger, nil
+}
+
+type timestampHook string
+
+func (h timestampHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Str(zerolog.TimestampFieldName, zerolog.TimestampFunc().Format(string(h)))
+}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger zerolog.Logger) context.Context {
+	return logger.WithContext(ctx)
+}
+
+// FromContext returns the logger carried by ctx, falling back to the default
+// context logger and then to the global logger.
+func FromContext(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log.Logger
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func decode(t *testing.T, line []byte) map[string]interface{} {
+	t.Helper()
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		t.Fatalf("log line %q: %v", line, err)
+	}
+	return fields
+}
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{
+		Level:      "WARN",
+		Service:    "svc",
+		Version:    "1.2.3",
+		Fields:     map[string]interface{}{"region": "eu"},
+		Output:     &out,
+		TimeFormat: time.RFC3339Nano,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info().Msg("dropped")
+	logger.Warn().Msg("kept")
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1 above the level: %s", len(lines), out.String())
+	}
+	fields := decode(t, lines[0])
+	for k, want := range map[string]interface{}{"service": "svc", "version": "1.2.3", "region": "eu", "message": "kept"} {
+		if fields[k] != want {
+			t.Errorf("%s = %v, want %v", k, fields[k], want)
+		}
+	}
+	ts, _ := fields[zerolog.TimestampFieldName].(string)
+	if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		t.Errorf("timestamp %q not in the configured format: %v", ts, err)
+	}
+	if zerolog.TimeFieldFormat != time.RFC3339 {
+		t.Errorf("zerolog.TimeFieldFormat changed to %q", zerolog.TimeFieldFormat)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{Level: "loud"}); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestNewConsole(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{Format: FormatConsole, Output: &out, TimeFormat: "15:04"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info().Str("k", "v").Msg("hello")
+	if got := out.String(); !strings.Contains(got, "hello") || !strings.Contains(got, "INF") {
+		t.Errorf("console output = %q", got)
+	}
+}
+
+func TestNewSampling(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{Output: &out, Sampling: &Sampling{Burst: 2, Period: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		logger.Info().Msg("event")
+	}
+	if n := strings.Count(out.String(), "event"); n != 2 {
+		t.Errorf("logged %d events, want the burst of 2", n)
+	}
+}
+
+func TestSetupAndContext(t *testing.T) {
+	global, defaultCtx := log.Logger, zerolog.DefaultContextLogger
+	t.Cleanup(func() {
+		log.Logger, zerolog.DefaultContextLogger = global, defaultCtx
+	})
+
+	var setupOut, ctxOut bytes.Buffer
+	if _, err := Setup(Config{Output: &setupOut, Service: "svc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	FromContext(context.Background()).Info().Msg("default")
+	if !strings.Contains(setupOut.String(), `"service":"svc"`) {
+		t.Errorf("context without a logger did not use the Setup logger: %q", setupOut.String())
+	}
+
+	ctx := WithContext(context.Background(), zerolog.New(&ctxOut))
+	FromContext(ctx).Info().Msg("scoped")
+	if !strings.Contains(ctxOut.String(), "scoped") || strings.Contains(setupOut.String(), "scoped") {
+		t.Errorf("context logger not preferred: ctx %q, setup %q", ctxOut.String(), setupOut.String())
+	}
+}
+
+func TestFromContextFallsBackToGlobal(t *testing.T) {
+	global, defaultCtx := log.Logger, zerolog.DefaultContextLogger
+	t.Cleanup(func() {
+		log.Logger, zerolog.DefaultContextLogger = global, defaultCtx
+	})
+	zerolog.DefaultContextLogger = nil
+	if got := FromContext(context.Background()); got != &log.Logger {
+		t.Errorf("FromContext = %p, want the global logger", got)
+	}
+}
@@ -0,0 +1,143 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	common "github.com/broxgit/common/http"
+)
+
+// LoopbackOptions configure the redirect listener of AuthCodeLogin.
+type LoopbackOptions struct {
+	// Port defaults to a free port. Servers that require registered redirect
+	// URIs usually need a fixed one.
+	Port int
+	// Path defaults to /callback.
+	Path string
+	// SuccessPage is shown in the browser once the code is received.
+	SuccessPage string
+}
+
+const defaultSuccessPage = "<html><body><p>Login complete. You can close this window.</p></body></html>"
+
+// AuthCodeLogin runs the authorization code grant with PKCE. It listens on a
+// loopback address for the redirect, passes the authorization URL to open,
+// which should launch a browser or print it for the user, and exchanges the
+// code it receives for a token.
+func (c *Config) AuthCodeLogin(ctx context.Context, opts LoopbackOptions, open func(authURL string) error) (*common.Token, error) {
+	if opts.Path == "" {
+		opts.Path = "/callback"
+	}
+	if opts.SuccessPage == "" {
+		opts.SuccessPage = defaultSuccessPage
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.Port))
+	if err != nil {
+		return nil, err
+	}
+	redirectURI := fmt.Sprintf("http://%s%s", ln.Addr(), opts.Path)
+
+	verifier, err := randomString(32)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(opts.Path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		var res result
+		if code := q.Get("error"); code != "" {
+			res.err = &Error{Code: code, Description: q.Get("error_description"), URI: q.Get("error_uri")}
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else if res.code = q.Get("code"); res.code == "" {
+			res.err = errors.New("oauth2: redirect has no code")
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, opts.SuccessPage)
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln) //nolint:errcheck // ErrServerClosed once Close is called
+	defer srv.Close()
+
+	if err := open(c.AuthCodeURL(state, redirectURI, verifier)); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-results:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return c.Exchange(ctx, res.code, redirectURI, verifier)
+	}
+}
+
+// AuthCodeURL returns the authorization URL for state and redirectURI, with
+// an S256 code challenge derived from verifier.
+func (c *Config) AuthCodeURL(state, redirectURI, verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if len(c.Scopes) > 0 {
+		q.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	sep := "?"
+	if strings.Contains(c.AuthURL, "?") {
+		sep = "&"
+	}
+	return c.AuthURL + sep + q.Encode()
+}
+
+// Exchange trades an authorization code for a token.
+func (c *Config) Exchange(ctx context.Context, code, redirectURI, verifier string) (*common.Token, error) {
+	return c.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
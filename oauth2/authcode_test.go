@@ -0,0 +1,87 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthCodeLogin(t *testing.T) {
+	var challenge string
+	srv := newTokenServer(t, func(form map[string]string) (int, interface{}) {
+		sum := sha256.Sum256([]byte(form["code_verifier"]))
+		if form["code"] != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]interface{}{"access_token": "tok", "refresh_token": "ref"}
+	})
+
+	tests := []struct {
+		name     string
+		redirect func(q url.Values) url.Values
+		wantErr  error
+	}{
+		{name: "code", redirect: func(q url.Values) url.Values {
+			return url.Values{"state": {q.Get("state")}, "code": {"the-code"}}
+		}},
+		{name: "error", redirect: func(q url.Values) url.Values {
+			return url.Values{"state": {q.Get("state")}, "error": {"access_denied"}}
+		}, wantErr: ErrAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{ClientID: "cli", AuthURL: "https://auth.example/authorize?tenant=x", TokenURL: srv.URL, Scopes: []string{"a", "b"}}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var forged int
+			tok, err := c.AuthCodeLogin(ctx, LoopbackOptions{}, func(authURL string) error {
+				u, err := url.Parse(authURL)
+				if err != nil {
+					return err
+				}
+				q := u.Query()
+				challenge = q.Get("code_challenge")
+				redirect, err := url.Parse(q.Get("redirect_uri"))
+				if err != nil {
+					return err
+				}
+				if q.Get("tenant") != "x" || q.Get("scope") != "a b" || q.Get("code_challenge_method") != "S256" ||
+					q.Get("client_id") != "cli" || redirect.Hostname() != "127.0.0.1" || redirect.Path != "/callback" {
+					t.Errorf("authorization URL = %s", authURL)
+				}
+
+				// A redirect with the wrong state is rejected and ignored.
+				redirect.RawQuery = url.Values{"state": {"forged"}, "code": {"stolen"}}.Encode()
+				resp, err := http.Get(redirect.String())
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				forged = resp.StatusCode
+
+				redirect.RawQuery = tt.redirect(q).Encode()
+				go func() {
+					if resp, err := http.Get(redirect.String()); err == nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil
+			})
+			if forged != http.StatusBadRequest {
+				t.Errorf("forged state got %d, want 400", forged)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (tok.AccessToken != "tok" || tok.RefreshToken != "ref") {
+				t.Fatalf("token = %+v", tok)
+			}
+		})
+	}
+}
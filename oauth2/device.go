@@ -0,0 +1,112 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+// DeviceAuthorization is the server's answer to a device authorization
+// request. Show the user UserCode and VerificationURI, or
+// VerificationURIComplete when set, then call DeviceToken.
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	Expiry                  time.Time
+	Interval                time.Duration
+}
+
+// DeviceAuthorize starts the device authorization grant.
+func (c *Config) DeviceAuthorize(ctx context.Context) (*DeviceAuthorization, error) {
+	form := url.Values{}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	var resp struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURL         string `json:"verification_url"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if err := c.post(ctx, c.DeviceAuthURL, form, &resp); err != nil {
+		return nil, err
+	}
+	if resp.DeviceCode == "" {
+		return nil, errors.New("oauth2: device authorization response has no device_code")
+	}
+	da := &DeviceAuthorization{
+		DeviceCode:              resp.DeviceCode,
+		UserCode:                resp.UserCode,
+		VerificationURI:         resp.VerificationURI,
+		VerificationURIComplete: resp.VerificationURIComplete,
+		Interval:                time.Duration(resp.Interval) * time.Second,
+	}
+	// Some servers predate the RFC and use verification_url.
+	if da.VerificationURI == "" {
+		da.VerificationURI = resp.VerificationURL
+	}
+	if resp.ExpiresIn > 0 {
+		da.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	if da.Interval <= 0 {
+		da.Interval = 5 * time.Second
+	}
+	return da, nil
+}
+
+// slowDownStep is how much a slow_down response lengthens the polling
+// interval (RFC 8628 section 3.5).
+var slowDownStep = 5 * time.Second
+
+// DeviceToken polls the token endpoint until the user approves or denies
+// the request, the device code expires or ctx is done. It returns
+// ErrAccessDenied or ErrExpiredToken for the latter outcomes.
+func (c *Config) DeviceToken(ctx context.Context, da *DeviceAuthorization) (*common.Token, error) {
+	interval := da.Interval
+	if !da.Expiry.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, da.Expiry)
+		defer cancel()
+	}
+	expired := func() bool { return !da.Expiry.IsZero() && !time.Now().Before(da.Expiry) }
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if expired() {
+				return nil, ErrExpiredToken
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		tok, err := c.token(ctx, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {da.DeviceCode},
+		})
+		if err != nil && ctx.Err() != nil && expired() {
+			return nil, ErrExpiredToken
+		}
+		var oauthErr *Error
+		if !errors.As(err, &oauthErr) {
+			return tok, err
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownStep
+		default:
+			return nil, err
+		}
+	}
+}
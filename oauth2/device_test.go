@@ -0,0 +1,78 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	slowDownStep = 10 * time.Millisecond
+	defer func() { slowDownStep = 5 * time.Second }()
+
+	tests := []struct {
+		name      string
+		responses []string
+		expiresIn int
+		wantErr   error
+		wantPolls int
+	}{
+		{name: "pending then approved", responses: []string{"authorization_pending", "slow_down", "authorization_pending", ""}, wantPolls: 4},
+		{name: "denied", responses: []string{"authorization_pending", "access_denied"}, wantErr: ErrAccessDenied, wantPolls: 2},
+		{name: "expired by server", responses: []string{"expired_token"}, wantErr: ErrExpiredToken, wantPolls: 1},
+		{name: "expired while pending", responses: nil, expiresIn: 1, wantErr: ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var polls []time.Time
+			srv := newTokenServer(t, func(form map[string]string) (int, interface{}) {
+				if form["device_code"] == "" {
+					return http.StatusOK, map[string]interface{}{
+						"device_code": "dev", "user_code": "ABCD", "verification_url": "https://example.com/device",
+						"expires_in": tt.expiresIn, "interval": 1,
+					}
+				}
+				polls = append(polls, time.Now())
+				code := "authorization_pending"
+				if len(polls) <= len(tt.responses) {
+					code = tt.responses[len(polls)-1]
+				}
+				if code == "" {
+					return http.StatusOK, map[string]interface{}{"access_token": "tok", "token_type": "Bearer"}
+				}
+				return http.StatusBadRequest, map[string]string{"error": code}
+			})
+			c := &Config{ClientID: "cli", DeviceAuthURL: srv.URL, TokenURL: srv.URL, HTTP: common.NewHTTPRetry(common.WithBackoff(0))}
+			ctx := context.Background()
+
+			da, err := c.DeviceAuthorize(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if da.VerificationURI != "https://example.com/device" || da.Interval != time.Second {
+				t.Fatalf("authorization = %+v", da)
+			}
+			da.Interval = 20 * time.Millisecond
+			tok, err := c.DeviceToken(ctx, da)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && tok.AccessToken != "tok" {
+				t.Fatalf("token = %+v", tok)
+			}
+			if tt.wantPolls > 0 && len(polls) != tt.wantPolls {
+				t.Fatalf("%d polls, want %d", len(polls), tt.wantPolls)
+			}
+			// slow_down lengthens every later interval.
+			if tt.name == "pending then approved" {
+				if gap := polls[3].Sub(polls[2]); gap < 30*time.Millisecond {
+					t.Fatalf("interval after slow_down = %s, want at least 30ms", gap)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+// JWTAssertion mints signed JWTs (RFC 7523). The algorithm follows the key:
+// RS256 for RSA, ES256 for P-256 ECDSA and EdDSA for Ed25519 keys.
+type JWTAssertion struct {
+	Issuer   string
+	Subject  string
+	Audience string
+	// KeyID is sent as the kid header when set.
+	KeyID string
+	// Lifetime defaults to five minutes.
+	Lifetime time.Duration
+	// Claims are added to the registered claims above.
+	Claims map[string]interface{}
+	Key    crypto.Signer
+}
+
+// ParsePrivateKeyPEM parses the first private key in data, which may be
+// PKCS #8, PKCS #1 RSA or SEC 1 EC encoded.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("oauth2: no private key in PEM data")
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("oauth2: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("oauth2: unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+}
+
+// algorithm follows the public key, so that signers backed by a KMS or HSM
+// work as well as in-memory private keys.
+func (a *JWTAssertion) algorithm() (string, error) {
+	switch k := a.Key.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("oauth2: ES256 requires a P-256 key")
+		}
+		return "ES256", nil
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("oauth2: unsupported signing key type %T", k)
+	}
+}
+
+// Sign returns a JWT issued now and the time it expires.
+func (a *JWTAssertion) Sign() (string, time.Time, error) {
+	alg, err := a.algorithm()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	lifetime := a.Lifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	now := time.Now()
+	exp := now.Add(lifetime)
+	jti, err := randomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if a.KeyID != "" {
+		header["kid"] = a.KeyID
+	}
+	claims := make(map[string]interface{}, len(a.Claims)+6)
+	for k, v := range a.Claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+	claims["jti"] = jti
+	for name, v := range map[string]string{"iss": a.Issuer, "sub": a.Subject, "aud": a.Audience} {
+		if v != "" {
+			claims[name] = v
+		}
+	}
+
+	var parts [2]string
+	for i, v := range []interface{}{header, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		parts[i] = base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := parts[0] + "." + parts[1]
+	sig, err := a.sign(alg, []byte(signingInput))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), exp, nil
+}
+
+func (a *JWTAssertion) sign(alg string, input []byte) ([]byte, error) {
+	if alg == "EdDSA" {
+		return a.Key.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(input)
+	sig, err := a.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || alg != "ES256" {
+		return sig, err
+	}
+	// JWS wants the fixed-size r || s rather than ASN.1.
+	r, s, err := parseECDSASignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 64)
+	r.FillBytes(out[:32])
+	s.FillBytes(out[32:])
+	return out, nil
+}
+
+// TokenSource returns a source sending the JWT itself as the bearer token,
+// minting a new one shortly before the previous one expires.
+func (a *JWTAssertion) TokenSource() common.TokenSource {
+	return &cachedTokenSource{fetch: func(context.Context) (*common.Token, error) {
+		jwt, exp, err := a.Sign()
+		if err != nil {
+			return nil, err
+		}
+		return &common.Token{AccessToken: jwt, TokenType: "Bearer", Expiry: exp}, nil
+	}, leeway: a.leeway()}
+}
+
+// JWTBearerTokenSource returns a source exchanging assertions from a for
+// access tokens at TokenURL using the JWT bearer grant (RFC 7523 section
+// 2.1).
+func (c *Config) JWTBearerTokenSource(a *JWTAssertion) common.TokenSource {
+	return &cachedTokenSource{fetch: func(ctx context.Context) (*common.Token, error) {
+		jwt, _, err := a.Sign()
+		if err != nil {
+			return nil, err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {jwt},
+		}
+		if len(c.Scopes) > 0 {
+			form.Set("scope", strings.Join(c.Scopes, " "))
+		}
+		return c.token(ctx, form)
+	}, leeway: expiryLeeway}
+}
+
+// leeway is a minute, or a fifth of short lifetimes, so a token is never
+// treated as expired as soon as it is minted.
+func (a *JWTAssertion) leeway() time.Duration {
+	if a.Lifetime > 0 && a.Lifetime/5 < expiryLeeway {
+		return a.Lifetime / 5
+	}
+	return expiryLeeway
+}
+
+type cachedTokenSource struct {
+	fetch  func(ctx context.Context) (*common.Token, error)
+	leeway time.Duration
+
+	mu  sync.Mutex
+	tok *common.Token
+}
+
+func (s *cachedTokenSource) Token(ctx context.Context) (*common.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.Valid(s.leeway) {
+		return s.tok, nil
+	}
+	tok, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+func parseECDSASignature(der []byte) (r, s *big.Int, err error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, nil, fmt.Errorf("oauth2: %w", err)
+	}
+	return sig.R, sig.S, nil
+}
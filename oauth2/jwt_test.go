@@ -0,0 +1,150 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// opaqueSigner hides the concrete key type, as KMS and HSM signers do.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestJWTAssertionSign(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		alg    string
+		verify func(input, sig []byte) bool
+	}{
+		{name: "RS256", key: rsaKey, alg: "RS256", verify: func(input, sig []byte) bool {
+			sum := sha256.Sum256(input)
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, sum[:], sig) == nil
+		}},
+		{name: "ES256", key: ecKey, alg: "ES256", verify: func(input, sig []byte) bool {
+			sum := sha256.Sum256(input)
+			return len(sig) == 64 && ecdsa.Verify(&ecKey.PublicKey, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+		}},
+		{name: "EdDSA", key: edKey, alg: "EdDSA", verify: func(input, sig []byte) bool {
+			return ed25519.Verify(edKey.Public().(ed25519.PublicKey), input, sig)
+		}},
+	}
+	for _, tt := range tests {
+		for name, key := range map[string]crypto.Signer{"key": tt.key, "opaque": opaqueSigner{tt.key}} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				a := &JWTAssertion{Issuer: "iss", Subject: "sub", Audience: "aud", KeyID: "k1", Claims: map[string]interface{}{"scope": "x"}, Key: key}
+				jwt, exp, err := a.Sign()
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts := strings.Split(jwt, ".")
+				if len(parts) != 3 {
+					t.Fatalf("jwt has %d parts", len(parts))
+				}
+				var header map[string]string
+				var claims map[string]interface{}
+				decodeSegment(t, parts[0], &header)
+				decodeSegment(t, parts[1], &claims)
+				if header["alg"] != tt.alg || header["kid"] != "k1" || header["typ"] != "JWT" {
+					t.Fatalf("header = %v", header)
+				}
+				if claims["iss"] != "iss" || claims["sub"] != "sub" || claims["aud"] != "aud" || claims["scope"] != "x" ||
+					int64(claims["exp"].(float64)) != exp.Unix() || exp.Sub(time.Now()) > 5*time.Minute {
+					t.Fatalf("claims = %v, exp = %s", claims, exp)
+				}
+				sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tt.verify([]byte(parts[0]+"."+parts[1]), sig) {
+					t.Fatal("signature does not verify")
+				}
+			})
+		}
+	}
+
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, _, err := (&JWTAssertion{Key: p384}).Sign(); err == nil {
+		t.Error("P-384 key accepted for ES256")
+	}
+}
+
+func decodeSegment(t *testing.T, segment string, v interface{}) {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("skipped")}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})...)
+	signer, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(signer) {
+		t.Fatal("parsed a different key")
+	}
+}
+
+func TestJWTTokenSourceCaching(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	a := &JWTAssertion{Issuer: "iss", Key: key}
+	ctx := context.Background()
+
+	ts := a.TokenSource()
+	first, _ := ts.Token(ctx)
+	second, _ := ts.Token(ctx)
+	if first.AccessToken != second.AccessToken {
+		t.Fatal("assertion not reused while valid")
+	}
+	ts.(*cachedTokenSource).tok.Expiry = time.Now().Add(30 * time.Second)
+	third, _ := ts.Token(ctx)
+	if third.AccessToken == first.AccessToken {
+		t.Fatal("assertion reused within the expiry leeway")
+	}
+
+	srv := newTokenServer(t, func(form map[string]string) (int, interface{}) {
+		if form["grant_type"] != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(form["assertion"], ".") != 2 {
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]interface{}{"access_token": "tok", "expires_in": 3600}
+	})
+	c := &Config{ClientID: "cli", TokenURL: srv.URL}
+	bearer := c.JWTBearerTokenSource(a)
+	for i := 0; i < 3; i++ {
+		tok, err := bearer.Token(ctx)
+		if err != nil || tok.AccessToken != "tok" {
+			t.Fatalf("token = %+v, %v", tok, err)
+		}
+	}
+	if n := len(srv.requests()); n != 1 {
+		t.Fatalf("%d token requests, want 1", n)
+	}
+}
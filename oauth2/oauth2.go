@@ -0,0 +1,176 @@
+// Package oauth2 implements the OAuth 2.0 user login flows used by command
+// line tools: the device authorization grant (RFC 8628) and the
+// authorization code grant with PKCE (RFC 7636) through a loopback redirect.
+// Services authenticate with signed JWT assertions (RFC 7523) through
+// JWTAssertion. Token endpoint calls go through common.HTTPRetry, and the
+// token sources plug into common.WithTokenSource.
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+var (
+	ErrAccessDenied  = errors.New("oauth2: access denied")
+	ErrExpiredToken  = errors.New("oauth2: device code expired")
+	ErrInvalidGrant  = errors.New("oauth2: invalid grant")
+	ErrLoginRequired = errors.New("oauth2: login required")
+)
+
+// Error is an error response from an authorization server.
+type Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2: %s: %s", e.Code, e.Description)
+	}
+	return "oauth2: " + e.Code
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrAccessDenied:
+		return e.Code == "access_denied"
+	case ErrExpiredToken:
+		return e.Code == "expired_token"
+	case ErrInvalidGrant:
+		return e.Code == "invalid_grant"
+	}
+	return false
+}
+
+// Config describes an OAuth 2.0 client and the authorization server's
+// endpoints. Public clients leave ClientSecret empty.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// AuthURL and TokenURL are used by the authorization code flow,
+	// DeviceAuthURL and TokenURL by the device flow.
+	AuthURL       string
+	TokenURL      string
+	DeviceAuthURL string
+	Scopes        []string
+	// HTTP defaults to a shared common.HTTPRetry with default settings. It
+	// may carry this config's own TokenSource: token endpoint calls are sent
+	// without it.
+	HTTP *common.HTTPRetry
+}
+
+var (
+	defaultHTTPOnce sync.Once
+	defaultHTTP     *common.HTTPRetry
+)
+
+func (c *Config) client() *common.HTTPRetry {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	defaultHTTPOnce.Do(func() { defaultHTTP = common.NewHTTPRetry() })
+	return defaultHTTP
+}
+
+// Refresh exchanges refreshToken for a new token. When the server does not
+// rotate the refresh token, the returned token keeps the old one.
+func (c *Config) Refresh(ctx context.Context, refreshToken string) (*common.Token, error) {
+	tok, err := c.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func (c *Config) token(ctx context.Context, form url.Values) (*common.Token, error) {
+	var resp tokenResponse
+	if err := c.post(ctx, c.TokenURL, form, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, errors.New("oauth2: token response has no access_token")
+	}
+	tok := &common.Token{AccessToken: resp.AccessToken, TokenType: resp.TokenType, RefreshToken: resp.RefreshToken}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// post sends form to endpoint with the client's credentials and decodes the
+// JSON response into v, turning error responses into *Error.
+func (c *Config) post(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	ctx = common.ContextWithoutTokenSource(ctx)
+	// Authorization codes and rotating refresh tokens are single use: a
+	// retry after the server consumed one fails with invalid_grant.
+	switch form.Get("grant_type") {
+	case "authorization_code", "refresh_token":
+		ctx = common.ContextWithRetries(ctx, 1)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" && resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode, Code: "server_error", Description: resp.Status}
+	}
+	var oauthErr struct {
+		Code        string `json:"error"`
+		Description string `json:"error_description"`
+		URI         string `json:"error_uri"`
+	}
+	if err := json.Unmarshal(body, &oauthErr); err == nil && oauthErr.Code != "" {
+		return &Error{StatusCode: resp.StatusCode, Code: oauthErr.Code, Description: oauthErr.Description, URI: oauthErr.URI}
+	}
+	if resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode, Code: "server_error", Description: resp.Status}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("oauth2: decoding response from %s: %w", endpoint, err)
+	}
+	return nil
+}
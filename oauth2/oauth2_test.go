@@ -0,0 +1,154 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+// tokenServer is an authorization server whose token endpoint answers with
+// respond, recording every form it receives.
+type tokenServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	forms   []map[string]string
+	respond func(form map[string]string) (int, interface{})
+}
+
+func newTokenServer(t *testing.T, respond func(form map[string]string) (int, interface{})) *tokenServer {
+	s := &tokenServer{respond: respond}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form := map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		s.mu.Lock()
+		s.forms = append(s.forms, form)
+		s.mu.Unlock()
+		status, body := s.respond(form)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) requests() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.forms...)
+}
+
+func TestSingleUseGrantsAreNotRetried(t *testing.T) {
+	srv := newTokenServer(t, func(map[string]string) (int, interface{}) {
+		return http.StatusServiceUnavailable, map[string]string{"error": "temporarily_unavailable"}
+	})
+	c := &Config{ClientID: "cli", TokenURL: srv.URL, HTTP: common.NewHTTPRetry(common.WithRetries(3), common.WithBackoff(0))}
+	ctx := context.Background()
+
+	c.Exchange(ctx, "code", "http://127.0.0.1/callback", "verifier")
+	c.Refresh(ctx, "refresh")
+	c.token(ctx, map[string][]string{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}})
+
+	counts := map[string]int{}
+	for _, form := range srv.requests() {
+		counts[form["grant_type"]]++
+	}
+	if counts["authorization_code"] != 1 || counts["refresh_token"] != 1 || counts["urn:ietf:params:oauth:grant-type:jwt-bearer"] != 3 {
+		t.Fatalf("attempts per grant = %v", counts)
+	}
+}
+
+func TestTokenSourceRefreshesThroughItsOwnClient(t *testing.T) {
+	srv := newTokenServer(t, func(form map[string]string) (int, interface{}) {
+		if form["refresh_token"] != "r1" {
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]interface{}{"access_token": "a2", "refresh_token": "r2", "expires_in": 3600}
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	store := FileTokenStore{Path: filepath.Join(t.TempDir(), "token.json")}
+	store.Save(context.Background(), &common.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: time.Now().Add(-time.Hour)})
+	c := &Config{ClientID: "cli", TokenURL: srv.URL}
+	c.HTTP = common.NewHTTPRetry(common.WithTokenSource(c.TokenSource(store)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, api.URL, nil)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var auth [64]byte
+	n, _ := resp.Body.Read(auth[:])
+	if got := string(auth[:n]); got != "Bearer a2" {
+		t.Fatalf("Authorization = %q, want Bearer a2", got)
+	}
+	if forms := srv.requests(); len(forms) != 1 {
+		t.Fatalf("%d token requests, want 1", len(forms))
+	}
+}
+
+func TestTokenSourceRotation(t *testing.T) {
+	rotate := true
+	srv := newTokenServer(t, func(form map[string]string) (int, interface{}) {
+		switch form["refresh_token"] {
+		case "revoked":
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		body := map[string]interface{}{"access_token": "new-" + form["refresh_token"], "expires_in": 3600}
+		if rotate {
+			body["refresh_token"] = "rotated"
+		}
+		return http.StatusOK, body
+	})
+	c := &Config{ClientID: "cli", TokenURL: srv.URL}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		refresh     string
+		rotate      bool
+		wantRefresh string
+		wantErr     error
+	}{
+		{name: "rotated", refresh: "r1", rotate: true, wantRefresh: "rotated"},
+		{name: "kept when not rotated", refresh: "r1", rotate: false, wantRefresh: "r1"},
+		{name: "revoked", refresh: "revoked", wantErr: ErrLoginRequired},
+		{name: "no refresh token", wantErr: ErrLoginRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotate = tt.rotate
+			store := FileTokenStore{Path: filepath.Join(t.TempDir(), "token.json")}
+			store.Save(ctx, &common.Token{AccessToken: "old", RefreshToken: tt.refresh, Expiry: time.Now().Add(-time.Hour)})
+
+			tok, err := c.TokenSource(store).Token(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			saved, _ := store.Load(ctx)
+			if tok.AccessToken != "new-"+tt.refresh || saved.RefreshToken != tt.wantRefresh || saved.AccessToken != tok.AccessToken {
+				t.Fatalf("token = %+v, saved = %+v", tok, saved)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	common "github.com/broxgit/common/http"
+)
+
+// TokenStore persists the token of a logged in user. Load returns nil and no
+// error when nothing is stored.
+type TokenStore interface {
+	Load(ctx context.Context) (*common.Token, error)
+	Save(ctx context.Context, tok *common.Token) error
+}
+
+// FileTokenStore keeps the token in a JSON file readable only by its owner.
+type FileTokenStore struct {
+	Path string
+}
+
+func (s FileTokenStore) Load(context.Context) (*common.Token, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil //nolint:nilnil // nothing stored
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tok common.Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+func (s FileTokenStore) Save(_ context.Context, tok *common.Token) error {
+	data, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// TokenSource returns a common.TokenSource serving the token in store,
+// refreshing it shortly before it expires and saving the result so that
+// rotated refresh tokens are not lost. When there is no usable token it
+// returns ErrLoginRequired; log the user in with DeviceToken or
+// AuthCodeLogin and Save the token to store.
+func (c *Config) TokenSource(store TokenStore) common.TokenSource {
+	return &tokenSource{config: c, store: store}
+}
+
+const expiryLeeway = time.Minute
+
+type tokenSource struct {
+	config *Config
+	store  TokenStore
+
+	mu  sync.Mutex
+	tok *common.Token
+}
+
+func (s *tokenSource) Token(ctx context.Context) (*common.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.Valid(expiryLeeway) {
+		return s.tok, nil
+	}
+	// Another process sharing the store may have refreshed already.
+	stored, err := s.store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Valid(expiryLeeway) {
+		s.tok = stored
+		return stored, nil
+	}
+	if stored == nil || stored.RefreshToken == "" {
+		return nil, ErrLoginRequired
+	}
+	tok, err := s.config.Refresh(ctx, stored.RefreshToken)
+	if errors.Is(err, ErrInvalidGrant) {
+		return nil, errors.Join(ErrLoginRequired, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Save(ctx, tok); err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}